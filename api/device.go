@@ -43,10 +43,34 @@ func (handler *DeviceAPIHandler) RouteRequest(w http.ResponseWriter, r *http.Req
 	case r.Method == http.MethodGet && deviceIDPattern.MatchString(relative):
 		deviceID := deviceIDPattern.FindStringSubmatch(relative)[1]
 		return handler.Retrieve(deviceID, w, r)
+	// PATCH /{deviceID}
+	case r.Method == http.MethodPatch && deviceIDPattern.MatchString(relative):
+		deviceID := deviceIDPattern.FindStringSubmatch(relative)[1]
+		return handler.Update(deviceID, w, r)
+	// GET /{deviceID}/public-key
+	case r.Method == http.MethodGet && devicePublicKeyPattern.MatchString(relative):
+		deviceID := devicePublicKeyPattern.FindStringSubmatch(relative)[1]
+		return handler.RetrievePublicKey(deviceID, w, r)
 	// POST /{deviceID}/sign
 	case r.Method == http.MethodPost && deviceSigningPattern.MatchString(relative):
 		deviceID := deviceSigningPattern.FindStringSubmatch(relative)[1]
 		return handler.Sign(deviceID, w, r)
+	// POST /{deviceID}/verify
+	case r.Method == http.MethodPost && deviceVerifyPattern.MatchString(relative):
+		deviceID := deviceVerifyPattern.FindStringSubmatch(relative)[1]
+		return handler.Verify(deviceID, w, r)
+	// GET /{deviceID}/signatures
+	case r.Method == http.MethodGet && deviceSignaturesPattern.MatchString(relative):
+		deviceID := deviceSignaturesPattern.FindStringSubmatch(relative)[1]
+		return handler.ListSignatures(deviceID, w, r)
+	// GET /{deviceID}/chain/verify
+	case r.Method == http.MethodGet && deviceChainVerifyPattern.MatchString(relative):
+		deviceID := deviceChainVerifyPattern.FindStringSubmatch(relative)[1]
+		return handler.VerifyChain(deviceID, w, r)
+	// POST /{deviceID}/rotate-key
+	case r.Method == http.MethodPost && deviceRotateKeyPattern.MatchString(relative):
+		deviceID := deviceRotateKeyPattern.FindStringSubmatch(relative)[1]
+		return handler.RotateKey(deviceID, w, r)
 	default:
 		return responses.UrlNotFoundError()
 	}
@@ -58,6 +82,21 @@ var deviceIDPattern = regexp.MustCompile("^([^/]+)$")
 // Matches a device signing endpoint path (deviceID/sign)
 var deviceSigningPattern = regexp.MustCompile("^([^/]+)/sign$")
 
+// Matches a device public key path (deviceID/public-key)
+var devicePublicKeyPattern = regexp.MustCompile("^([^/]+)/public-key$")
+
+// Matches a device verification endpoint path (deviceID/verify)
+var deviceVerifyPattern = regexp.MustCompile("^([^/]+)/verify$")
+
+// Matches a device key rotation endpoint path (deviceID/rotate-key)
+var deviceRotateKeyPattern = regexp.MustCompile("^([^/]+)/rotate-key$")
+
+// Matches a device signatures listing path (deviceID/signatures)
+var deviceSignaturesPattern = regexp.MustCompile("^([^/]+)/signatures$")
+
+// Matches a device signature chain verification path (deviceID/chain/verify)
+var deviceChainVerifyPattern = regexp.MustCompile("^([^/]+)/chain/verify$")
+
 func (h *DeviceAPIHandler) SetPathPrefix(prefix string) {
 	h.Prefix = prefix
 }
@@ -6,8 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/AloveIs/signing-device-service-go/api/responses"
+	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
 	"github.com/AloveIs/signing-device-service-go/domain"
 )
 
@@ -19,24 +24,131 @@ func (handler *DeviceAPIHandler) Retrieve(deviceID string, w http.ResponseWriter
 	} else if err != nil {
 		return err
 	}
+	w.Header().Set("ETag", deviceETag(device.Version))
 	WriteAPIResponse(w, http.StatusOK, device)
 	return nil
 }
 
-// List all devices
+// Media types of the public key representations
+const (
+	mediaTypePEM         = "application/x-pem-file"
+	mediaTypeDER         = "application/pkix-spki"
+	mediaTypeOctetStream = "application/octet-stream"
+)
+
+// RetrievePublicKey writes the public key of a device, PEM encoded by default or
+// DER encoded if requested through the Accept header.
+func (handler *DeviceAPIHandler) RetrievePublicKey(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	mediaType, ok := negotiatePublicKeyType(r.Header.Get("Accept"))
+	if !ok {
+		return responses.NewAPIError(http.StatusNotAcceptable,
+			fmt.Sprintf("supported media types: %s, %s, %s", mediaTypePEM, mediaTypeDER, mediaTypeOctetStream))
+	}
+
+	device, err := handler.service.GetDeviceByID(deviceID)
+	if err != nil && errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if err != nil {
+		return err
+	}
+
+	body := []byte(device.PublicKey)
+	if mediaType != mediaTypePEM {
+		_, body, err = crypto.DecodePublicKey(device.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	return nil
+}
+
+// negotiatePublicKeyType picks the first media type of the Accept header that
+// represents a public key. An empty header or a wildcard select PEM.
+func negotiatePublicKeyType(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypePEM, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch mediaType = strings.TrimSpace(mediaType); mediaType {
+		case mediaTypePEM, mediaTypeDER, mediaTypeOctetStream:
+			return mediaType, true
+		case "*/*", "application/*", "text/plain":
+			return mediaTypePEM, true
+		}
+	}
+	return "", false
+}
+
+// List a page of devices ordered by ID. The query parameters algorithm, label and status
+// filter the devices, limit and cursor paginate them.
 func (handler *DeviceAPIHandler) List(w http.ResponseWriter, r *http.Request) error {
-	devices, err := handler.service.GetAllDevices()
-	if err != nil {
+	values := r.URL.Query()
+	cursor, limit, errs := parsePage(values)
+	if len(errs) > 0 {
+		return responses.InvalidRequestData(errs)
+	}
+	filter := domain.DeviceFilter{
+		Algorithm: values.Get("algorithm"),
+		Label:     values.Get("label"),
+		Status:    values.Get("status"),
+	}
+
+	devices, next, err := handler.service.QueryDevices(filter, cursor, limit)
+
+	if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
 		return err
 	}
-	WriteAPIResponse(w, http.StatusOK, devices)
+	WriteAPIPage(w, http.StatusOK, devices, next)
 	return nil
 }
 
 // Intermediate data type to parse a the request data for creating a device
 type CreateDeviceRequest struct {
+	// ID is the optional identifier of the device, a UUID or an ID matching the configured
+	// pattern. A new UUID is generated if it is not set.
+	ID        *string `json:"id"`
 	Label     *string `json:"label"`
 	Algorithm string  `json:"algorithm"`
+	// KeySize is the optional RSA modulus size in bits
+	KeySize *int `json:"key_size"`
+	// Curve is the optional elliptic curve of an ECC key
+	Curve *string `json:"curve"`
+	// Scheme is the optional RSA signature scheme ("PKCS1v15" or "PSS")
+	Scheme *string `json:"scheme"`
+	// SaltLength is the optional salt length in bytes of the PSS scheme
+	SaltLength *int `json:"salt_length"`
+	// Hash is the optional digest of the PSS scheme
+	Hash *string `json:"hash"`
+	// KeyBackend is the optional key store generating the key (e.g. "pkcs11")
+	KeyBackend *string `json:"key_backend"`
+	// PrivateKey is an optional PEM encoded private key (PKCS#1, SEC 1 or PKCS#8)
+	// to import instead of generating a new one
+	PrivateKey *string `json:"private_key"`
+}
+
+// signerOptions collects the optional key parameters of the request.
+func (v *CreateDeviceRequest) signerOptions() crypto.SignerOptions {
+	var options crypto.SignerOptions
+	if v.KeySize != nil {
+		options.KeySize = *v.KeySize
+	}
+	if v.Curve != nil {
+		options.Curve = *v.Curve
+	}
+	if v.Scheme != nil {
+		options.Scheme = *v.Scheme
+	}
+	options.SaltLength = v.SaltLength
+	if v.Hash != nil {
+		options.Hash = *v.Hash
+	}
+	return options
 }
 
 // Validate checks that the CreateDeviceRequest has all the required fields.
@@ -46,6 +158,18 @@ func (v *CreateDeviceRequest) Validate() []string {
 	if len(v.Algorithm) == 0 {
 		errors = append(errors, "algorithm: value is required")
 	}
+	if v.ID != nil && len(*v.ID) == 0 {
+		errors = append(errors, "id: value must not be empty")
+	}
+	if v.KeySize != nil && *v.KeySize <= 0 {
+		errors = append(errors, "key_size: value must be positive")
+	}
+	if v.PrivateKey != nil && strings.TrimSpace(*v.PrivateKey) == "" {
+		errors = append(errors, "private_key: value must not be empty")
+	}
+	if v.PrivateKey != nil && v.KeyBackend != nil && *v.KeyBackend != crypto.KeyBackendSoftware {
+		errors = append(errors, fmt.Sprintf("private_key: keys can only be imported in the %s key backend", crypto.KeyBackendSoftware))
+	}
 
 	if len(errors) > 0 {
 		return errors
@@ -67,12 +191,28 @@ func (handler *DeviceAPIHandler) Create(w http.ResponseWriter, r *http.Request)
 		return responses.InvalidRequestData(errs)
 	}
 
-	device, err := handler.service.CreateDevice(req.Algorithm, req.Label)
-	if validationErr, ok := err.(*domain.ValidationError); ok {
+	var id string
+	if req.ID != nil {
+		id = *req.ID
+	}
+	var device common.Device
+	if req.PrivateKey != nil {
+		device, err = handler.service.ImportDevice(id, req.Algorithm, req.signerOptions(), []byte(*req.PrivateKey), req.Label)
+	} else {
+		var backend string
+		if req.KeyBackend != nil {
+			backend = *req.KeyBackend
+		}
+		device, err = handler.service.CreateDeviceOnBackend(backend, id, req.Algorithm, req.signerOptions(), req.Label)
+	}
+	if errors.Is(err, domain.ErrDeviceAlreadyExists) {
+		return responses.NewAPIError(http.StatusConflict, err.Error())
+	} else if validationErr, ok := err.(*domain.ValidationError); ok {
 		return responses.InvalidRequestData(validationErr.Errors)
 	} else if err != nil {
 		return err
 	}
+	w.Header().Set("ETag", deviceETag(device.Version))
 	WriteAPIResponse(w, http.StatusCreated, device)
 	return nil
 }
@@ -115,7 +255,8 @@ func (v *SignMessageRequest) Validate() ([]byte, []string) {
 	return v.getMessageBytes(), nil
 }
 
-// Sign a message using the device defined by deviceID. The request must contain a SignMessageRequest.
+// Sign a message using the device defined by deviceID. The request must contain a SignMessageRequest
+// and can be made conditional on the version of the device with If-Match.
 func (handler *DeviceAPIHandler) Sign(deviceID string, w http.ResponseWriter, r *http.Request) error {
 
 	// validate input data to be SignMessageRequest
@@ -129,14 +270,250 @@ func (handler *DeviceAPIHandler) Sign(deviceID string, w http.ResponseWriter, r
 	if len(errs) != 0 {
 		return responses.InvalidRequestData(errs)
 	}
-	signature, err := handler.service.SignMessageWithDevice(deviceID, messageBytes)
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		return err
+	}
+	signature, version, err := handler.service.SignMessageWithDeviceIfVersion(deviceID, messageBytes, ifVersion)
 
 	if errors.Is(err, domain.ErrDeviceNotFound) {
 		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if errors.Is(err, domain.ErrDeviceNotActive) {
+		return responses.NewAPIError(http.StatusConflict, err.Error())
+	} else if errors.Is(err, domain.ErrVersionMismatch) {
+		return responses.NewAPIError(http.StatusPreconditionFailed, err.Error())
 	} else if err != nil {
 		return err
 	}
 
+	// the signature changed the device, its new version lets the client chain signatures
+	w.Header().Set("ETag", deviceETag(version))
 	WriteAPIResponse(w, http.StatusCreated, signature)
 	return nil
 }
+
+// Intermediate data type to parse a the request data for verifying a signature
+type VerifySignatureRequest struct {
+	SignedData *string `json:"signed_data"`
+	Signature  *string `json:"signature"`
+	// KeyVersion optionally selects the device key, by default all the keys are tried
+	KeyVersion *int `json:"key_version"`
+}
+
+// Validate checks that the VerifySignatureRequest has all the required fields and
+// that the signature can be decoded from base64.
+// Returns the signature bytes and a list of human readable error messages.
+func (v *VerifySignatureRequest) Validate() ([]byte, []string) {
+	errors := make([]string, 0)
+	if v.SignedData == nil {
+		errors = append(errors, "signed_data: value is required")
+	}
+	if v.Signature == nil {
+		errors = append(errors, "signature: value is required")
+	}
+	if v.KeyVersion != nil && *v.KeyVersion <= 0 {
+		errors = append(errors, "key_version: value must be positive")
+	}
+	var signature []byte
+	if v.Signature != nil {
+		var err error
+		signature, err = base64.StdEncoding.DecodeString(*v.Signature)
+		if err != nil {
+			errors = append(errors, "signature cannot be decoded from base64")
+		}
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return signature, nil
+}
+
+// Verify a signature against the key of the device defined by deviceID. The request must
+// contain a VerifySignatureRequest.
+func (handler *DeviceAPIHandler) Verify(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	var payload VerifySignatureRequest
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		return responses.InvalidJSON()
+	}
+	signature, errs := payload.Validate()
+	if len(errs) != 0 {
+		return responses.InvalidRequestData(errs)
+	}
+	var keyVersion int
+	if payload.KeyVersion != nil {
+		keyVersion = *payload.KeyVersion
+	}
+	result, err := handler.service.VerifySignature(deviceID, []byte(*payload.SignedData), signature, keyVersion)
+
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
+		return err
+	}
+
+	WriteAPIResponse(w, http.StatusOK, result)
+	return nil
+}
+
+// VerifyChain audits the whole chain of signatures of the device defined by deviceID and
+// reports the first broken link, if any.
+func (handler *DeviceAPIHandler) VerifyChain(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	report, err := handler.service.VerifySignatureChain(deviceID)
+
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if err != nil {
+		return err
+	}
+
+	WriteAPIResponse(w, http.StatusOK, report)
+	return nil
+}
+
+// RotateKey generates a new key pair for the device defined by deviceID. The previous
+// key is kept in the key history of the device to verify the signatures it produced.
+// The rotation can be made conditional on the version of the device with If-Match.
+func (handler *DeviceAPIHandler) RotateKey(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		return err
+	}
+	device, err := handler.service.RotateDeviceKeyIfVersion(deviceID, ifVersion)
+
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if errors.Is(err, domain.ErrDeviceNotActive) {
+		return responses.NewAPIError(http.StatusConflict, err.Error())
+	} else if errors.Is(err, domain.ErrVersionMismatch) {
+		return responses.NewAPIError(http.StatusPreconditionFailed, err.Error())
+	} else if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", deviceETag(device.Version))
+	WriteAPIResponse(w, http.StatusOK, device)
+	return nil
+}
+
+// Intermediate data type to parse a the request data for updating a device
+type UpdateDeviceRequest struct {
+	// Status is the new lifecycle state: ACTIVE, SUSPENDED or DECOMMISSIONED
+	Status *string `json:"status,omitempty"`
+	// Label is the new label of the device, an empty label removes it
+	Label *string `json:"label,omitempty"`
+	// Metadata replace all the metadata tags of the device, an empty object removes them
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Validate checks that the UpdateDeviceRequest changes at least one field.
+// Returns a list of human readable error messages.
+func (v *UpdateDeviceRequest) Validate() []string {
+	if v.Status == nil && v.Label == nil && v.Metadata == nil {
+		return []string{"status, label or metadata: a value is required"}
+	}
+	return nil
+}
+
+// Update the status, label or metadata of the device defined by deviceID. The request must
+// contain an UpdateDeviceRequest and can be made conditional on the version of the device
+// with If-Match.
+func (handler *DeviceAPIHandler) Update(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	var req UpdateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return responses.InvalidJSON()
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		return responses.InvalidRequestData(errs)
+	}
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		return err
+	}
+
+	device, err := handler.service.UpdateDevice(deviceID, domain.DeviceUpdate{
+		Status:    req.Status,
+		Label:     req.Label,
+		Metadata:  req.Metadata,
+		IfVersion: ifVersion,
+	})
+
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if errors.Is(err, domain.ErrInvalidStatusTransition) {
+		return responses.NewAPIError(http.StatusConflict, err.Error())
+	} else if errors.Is(err, domain.ErrVersionMismatch) {
+		return responses.NewAPIError(http.StatusPreconditionFailed, err.Error())
+	} else if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", deviceETag(device.Version))
+	WriteAPIResponse(w, http.StatusOK, device)
+	return nil
+}
+
+// parseSignatureQuery reads the offset and counter range (counter_from, counter_to)
+// query parameters of a signatures listing.
+// Returns the query and a list of human readable error messages.
+func parseSignatureQuery(values url.Values) (common.SignatureQuery, []string) {
+	var query common.SignatureQuery
+	errors := make([]string, 0)
+	if value := values.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			errors = append(errors, "offset: value must be a non-negative integer")
+		}
+		query.Offset = offset
+	}
+	if value := values.Get("counter_from"); value != "" {
+		counter, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			errors = append(errors, "counter_from: value must be a non-negative integer")
+		}
+		query.CounterFrom = counter
+	}
+	if value := values.Get("counter_to"); value != "" {
+		counter, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || counter == 0 {
+			errors = append(errors, "counter_to: value must be a positive integer")
+		}
+		query.CounterTo = counter
+	}
+	if len(errors) > 0 {
+		return common.SignatureQuery{}, errors
+	}
+	return query, nil
+}
+
+// ListSignatures lists a page of the signatures of the device defined by deviceID ordered by
+// counter. The query parameters limit and cursor (or offset) paginate the signatures,
+// counter_from (included) and counter_to (excluded) select a range of counters.
+func (handler *DeviceAPIHandler) ListSignatures(deviceID string, w http.ResponseWriter, r *http.Request) error {
+	cursor, limit, errs := parsePage(r.URL.Query())
+	query, queryErrs := parseSignatureQuery(r.URL.Query())
+	if errs = append(errs, queryErrs...); len(errs) > 0 {
+		return responses.InvalidRequestData(errs)
+	}
+	query.Limit = limit
+
+	signatures, next, err := handler.service.ListDeviceSignatures(deviceID, query, cursor)
+
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return responses.NewAPIError(http.StatusNotFound, fmt.Sprintf("device %s not found", deviceID))
+	} else if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
+		return err
+	}
+
+	WriteAPIPage(w, http.StatusOK, signatures, next)
+	return nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AloveIs/signing-device-service-go/api/responses"
+)
+
+// deviceETag returns the entity tag of a version of a device, a strong validator
+func deviceETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseIfMatch reads the If-Match precondition of a request changing a device.
+// Returns the version the device must be at, nil if the header is missing or "*".
+// Weak or unknown entity tags cannot match any version of a device, they fail the request
+// with 412 Precondition Failed; a list of entity tags is not supported.
+func parseIfMatch(r *http.Request) (*uint64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	if len(header) > 2 && strings.HasPrefix(header, `"`) && strings.HasSuffix(header, `"`) {
+		if version, err := strconv.ParseUint(header[1:len(header)-1], 10, 64); err == nil {
+			return &version, nil
+		}
+	}
+	if strings.Contains(header, ",") {
+		return nil, responses.NewAPIError(http.StatusBadRequest, "If-Match: a single entity tag or * is supported")
+	}
+	return nil, responses.NewAPIError(http.StatusPreconditionFailed, "If-Match: entity tag does not match the device")
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 
@@ -11,14 +13,17 @@ import (
 type Server struct {
 	listenAddress string
 	mux           *http.ServeMux
+	httpServer    *http.Server
 }
 
 // NewServer is a factory to instantiate a new Server. Pass the addess is
 // a string pair "address:port"
 func NewServer(listenAddress string) *Server {
+	mux := http.NewServeMux()
 	return &Server{
 		listenAddress: listenAddress,
-		mux:           http.NewServeMux(),
+		mux:           mux,
+		httpServer:    &http.Server{Addr: listenAddress, Handler: mux},
 	}
 }
 
@@ -39,9 +44,18 @@ func (s *Server) middleware(fn http.HandlerFunc) http.HandlerFunc {
 }
 
 // Run starts the HTTP server with the registered handlers. This function
-// blocks the caller until the server stops.
+// blocks the caller until the server stops, it returns nil after Shutdown.
 func (s *Server) Run() error {
-	return http.ListenAndServe(s.listenAddress, s.mux)
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the server, waiting for the requests in progress until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
 // errorResponseWrapper wraps an ErrorableHttpHandler to handle error responses,
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/AloveIs/signing-device-service-go/api/responses"
 	"github.com/AloveIs/signing-device-service-go/domain"
@@ -56,12 +57,38 @@ func (handler *SignatureAPIHandler) Retrieve(signatureID string, w http.Response
 	return nil
 }
 
-// List all signatures
+// List a page of signatures ordered by creation time. The query parameters device_id,
+// from (included) and to (excluded) filter the signatures, limit and cursor paginate them.
+// Times are formatted as RFC 3339.
 func (handler *SignatureAPIHandler) List(w http.ResponseWriter, r *http.Request) error {
-	signatures, err := handler.service.ListSignatures()
-	if err != nil {
+	values := r.URL.Query()
+	cursor, limit, errs := parsePage(values)
+	filter := domain.SignatureFilter{DeviceID: values.Get("device_id")}
+	if value := values.Get("from"); value != "" {
+		from, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			errs = append(errs, "from: value must be an RFC 3339 time")
+		}
+		filter.From = from
+	}
+	if value := values.Get("to"); value != "" {
+		to, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			errs = append(errs, "to: value must be an RFC 3339 time")
+		}
+		filter.To = to
+	}
+	if len(errs) > 0 {
+		return responses.InvalidRequestData(errs)
+	}
+
+	signatures, next, err := handler.service.QuerySignatures(filter, cursor, limit)
+
+	if validationErr, ok := err.(*domain.ValidationError); ok {
+		return responses.InvalidRequestData(validationErr.Errors)
+	} else if err != nil {
 		return err
 	}
-	WriteAPIResponse(w, http.StatusOK, signatures)
+	WriteAPIPage(w, http.StatusOK, signatures, next)
 	return nil
 }
@@ -3,11 +3,15 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 // Response is the generic API response container.
 type Response struct {
 	Data interface{} `json:"data"`
+	// NextCursor is the cursor of the next page of a list, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // ErrorResponse is the generic error API response container.
@@ -41,16 +45,48 @@ func WriteErrorResponse(w http.ResponseWriter, code int, errors any) {
 // WriteAPIResponse takes an HTTP status code and a generic data struct
 // and writes those as an HTTP response in a structured format.
 func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
-	w.WriteHeader(code)
-
 	response := Response{
 		Data: data,
 	}
+	WriteJSON(w, code, response)
+}
 
-	bytes, err := json.MarshalIndent(response, "", "  ")
+// WriteAPIPage takes an HTTP status code, a page of a list and the cursor of the next
+// page and writes those as an HTTP response in a structured format.
+func WriteAPIPage(w http.ResponseWriter, code int, data interface{}, nextCursor string) {
+	response := Response{
+		Data:       data,
+		NextCursor: nextCursor,
+	}
+	WriteJSON(w, code, response)
+}
+
+// parsePage reads the pagination query parameters of a list, the cursor of
+// the previous page and the page size (limit, zero if not set).
+// Returns a list of human readable error messages.
+func parsePage(values url.Values) (string, int, []string) {
+	var limit int
+	if value := values.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return "", 0, []string{"limit: value must be a positive integer"}
+		}
+	}
+	return values.Get("cursor"), limit, nil
+}
+
+// WriteJSON takes an HTTP status code and a value and writes the value
+// as a JSON HTTP response without the Response container. Use it for documents
+// with a standardized format (e.g. JWKS).
+func WriteJSON(w http.ResponseWriter, code int, value interface{}) {
+	bytes, err := json.MarshalIndent(value, "", "  ")
 	if err != nil {
 		WriteInternalError(w)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(bytes)
 }
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/AloveIs/signing-device-service-go/api/responses"
+	"github.com/AloveIs/signing-device-service-go/crypto"
+	"github.com/AloveIs/signing-device-service-go/domain"
+)
+
+// WellKnownHandler exposes the well-known documents (RFC 8615) of the service.
+type WellKnownHandler struct {
+	service *domain.DeviceService
+	Prefix  string
+}
+
+// Create a new WellKnownHandler using the provided service
+func NewWellKnownHandler(service *domain.DeviceService) *WellKnownHandler {
+	return &WellKnownHandler{
+		service: service,
+		Prefix:  "",
+	}
+}
+
+// JWKSResponse is the JSON Web Key Set (RFC 7517) document.
+type JWKSResponse struct {
+	Keys []crypto.JWK `json:"keys"`
+}
+
+// RouteRequest routes an http request to its handler.
+func (handler *WellKnownHandler) RouteRequest(w http.ResponseWriter, r *http.Request) error {
+	relative, found := strings.CutPrefix(r.URL.Path, handler.Prefix)
+	if !found {
+		return responses.UrlNotFoundError()
+	}
+
+	switch {
+	// GET /jwks.json
+	case r.Method == http.MethodGet && relative == "jwks.json":
+		return handler.JWKS(w, r)
+	default:
+		return responses.UrlNotFoundError()
+	}
+}
+
+// JWKS writes the public keys of the devices as a JSON Web Key Set. The document
+// is not wrapped in the Response container, so that standard JOSE clients can read it.
+func (handler *WellKnownHandler) JWKS(w http.ResponseWriter, r *http.Request) error {
+	keys, err := handler.service.GetJWKS()
+	if err != nil {
+		return err
+	}
+	WriteJSON(w, http.StatusOK, JWKSResponse{Keys: keys})
+	return nil
+}
+
+func (h *WellKnownHandler) SetPathPrefix(prefix string) {
+	h.Prefix = prefix
+}
+
+func (handler *WellKnownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	return handler.RouteRequest(w, r)
+}
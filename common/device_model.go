@@ -1,22 +1,117 @@
 package common
 
+import "time"
+
 // Data representing a signature device.
 // It is meant to be serialized toe xternal services
 type Device struct {
 	ID        string  `json:"id"`
 	Algorithm string  `json:"algorithm"`
 	Label     *string `json:"label"`
-	// TODO: check if public key needs to be sent to the client for local verification
-	PublicKey string `json:"-"`
+	// Metadata are user defined key/value tags, e.g. a store ID or a cash register serial
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// KeySize is the size of the device key in bits
+	KeySize int `json:"key_size,omitempty"`
+	// Curve is the elliptic curve of the device key, only for ECC devices
+	Curve string `json:"curve,omitempty"`
+	// Scheme is the signature scheme (padding) of RSA devices
+	Scheme string `json:"scheme,omitempty"`
+	// SaltLength is the salt length in bytes of the RSA-PSS scheme
+	SaltLength int `json:"salt_length,omitempty"`
+	// Hash is the digest applied to the data before signing
+	Hash string `json:"hash,omitempty"`
+	// KeyBackend is the key store holding the private key (e.g. "software", "pkcs11")
+	KeyBackend string `json:"key_backend"`
+	// PublicKey is the PKIX PEM encoded public key, to verify signatures offline
+	PublicKey string `json:"public_key"`
+	// KeyVersion is the version of the current key, incremented at every rotation
+	KeyVersion int `json:"key_version"`
+	// KeyHistory lists the retired keys of the device, oldest first
+	KeyHistory []RetiredKey `json:"key_history,omitempty"`
+	// Status is the lifecycle state of the device: ACTIVE, SUSPENDED or DECOMMISSIONED
+	Status string `json:"status"`
+	// FinalCounter is the signature counter of a decommissioned device
+	FinalCounter *uint64 `json:"final_counter,omitempty"`
+	// DecommissionedAt is the time a decommissioned device has been retired
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"`
+	// Version is incremented at every change of the device, it is also its ETag
+	Version uint64 `json:"version"`
+}
+
+// RetiredKey is a previous key of a device, kept to verify the signatures it produced.
+// The key signed the signatures with counter in [CounterFrom, CounterTo).
+type RetiredKey struct {
+	Version    int    `json:"version"`
+	KeySize    int    `json:"key_size,omitempty"`
+	Curve      string `json:"curve,omitempty"`
+	Scheme     string `json:"scheme,omitempty"`
+	SaltLength int    `json:"salt_length,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	PublicKey  string `json:"public_key"`
+	// CounterFrom is the signature counter of the first signature of the key
+	CounterFrom uint64 `json:"counter_from"`
+	// CounterTo is the signature counter the next key started from
+	CounterTo uint64 `json:"counter_to"`
 }
 
 // DeviceDTO for the device for communicating with the persistence layer
 type DeviceDTO struct {
-	ID               string
-	Label            *string
-	Algorithm        string
-	PrivateKey       []byte
-	PublicKey        []byte
+	ID         string
+	Label      *string
+	Metadata   map[string]string
+	Algorithm  string
+	KeySize    int
+	Curve      string
+	Scheme     string
+	SaltLength int
+	Hash       string
+	// KeyBackend is the key store holding the private key, empty for keys
+	// stored with the device
+	KeyBackend string
+	// KeyLabel references the private key in the KeyBackend store, the key material
+	// of external keys is never stored with the device
+	KeyLabel string
+	// PrivateKey is the encoded private key, encrypted if KEKID is set
+	PrivateKey []byte
+	PublicKey  []byte
+	// KEKID identifies the key-encryption key wrapping WrappedKey,
+	// empty if the private key is stored in plain
+	KEKID string
+	// WrappedKey is the data-encryption key of PrivateKey, wrapped with the KEK
+	WrappedKey []byte
+	// KeyVersion is the version of the current key, zero is the first version
+	// of devices stored before keys could be rotated
+	KeyVersion int
+	// KeyHistory holds the public keys of the retired key versions
+	KeyHistory []RetiredKey
+	// Status is the lifecycle state of the device, empty for devices stored
+	// before the lifecycle was introduced, which are active
+	Status string
+	// FinalCounter and DecommissionedAt are only set on decommissioned devices
+	FinalCounter     uint64
+	DecommissionedAt time.Time
 	SignatureCounter uint64
 	LastSignature    string
+	// Version is set to 1 when the device is saved and incremented by every committed
+	// update, zero for devices stored before versions were introduced
+	Version uint64
+}
+
+// DeviceQuery selects a page of devices, ordered by ID. Empty filters match any device.
+type DeviceQuery struct {
+	Algorithm string
+	Label     string
+	Status    string
+	// AfterID is the ID of the last device of the previous page, empty for the first page
+	AfterID string
+	// Limit is the maximum number of devices returned, zero for no limit
+	Limit int
+}
+
+// Matches reports whether the device satisfies the filters and follows AfterID
+func (q DeviceQuery) Matches(device DeviceDTO) bool {
+	return device.ID > q.AfterID &&
+		(q.Algorithm == "" || device.Algorithm == q.Algorithm) &&
+		(q.Label == "" || (device.Label != nil && *device.Label == q.Label)) &&
+		(q.Status == "" || device.Status == q.Status)
 }
@@ -1,12 +1,31 @@
 package common
 
+import "time"
+
 // Signature represents a signature created using a device
 // It is meant to be serialized toe xternal services
 type Signature struct {
-	ID         string `json:"id"`
-	DeviceID   string `json:"device_id"`
-	Signature  string `json:"signature"`
+	ID        string `json:"id"`
+	DeviceID  string `json:"device_id"`
+	Signature string `json:"signature"`
+	// SignedData is the secured data signed by the device:
+	// <counter>_<data_to_be_signed>_<previous_signature>
 	SignedData string `json:"signed_data"`
+	// Counter is the signature counter of the device, the prefix of the signed data
+	Counter uint64 `json:"counter"`
+	// DataToBeSigned is the base64 encoded message
+	DataToBeSigned string `json:"data_to_be_signed"`
+	// PreviousSignature is the previous signature of the device, or the base64 encoded
+	// device ID for the first signature
+	PreviousSignature string `json:"previous_signature"`
+	// Algorithm is the signing algorithm of the device
+	Algorithm string `json:"algorithm"`
+	// Hash is the digest applied to the signed data, empty if the algorithm signs it directly
+	Hash string `json:"hash,omitempty"`
+	// KeyVersion is the version of the device key that produced the signature
+	KeyVersion int `json:"key_version"`
+	// CreatedAt is the server time the signature has been created
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // SignatureDTO for communicating with the persistence layer
@@ -15,14 +34,113 @@ type SignatureDTO struct {
 	DeviceID   string
 	Signature  string
 	SignedData string
+	Counter    uint64
+	// DataToBeSigned and PreviousSignature are the parts of SignedData after the counter
+	DataToBeSigned    string
+	PreviousSignature string
+	Algorithm         string
+	Hash              string
+	KeyVersion        int
+	CreatedAt         time.Time
 }
 
 // ToSignature converts a SignatureDTO to a Signature
 func (dto *SignatureDTO) ToSignature() Signature {
 	return Signature{
-		ID:         dto.ID,
-		DeviceID:   dto.DeviceID,
-		Signature:  dto.Signature,
-		SignedData: dto.SignedData,
+		ID:                dto.ID,
+		DeviceID:          dto.DeviceID,
+		Signature:         dto.Signature,
+		SignedData:        dto.SignedData,
+		Counter:           dto.Counter,
+		DataToBeSigned:    dto.DataToBeSigned,
+		PreviousSignature: dto.PreviousSignature,
+		Algorithm:         dto.Algorithm,
+		Hash:              dto.Hash,
+		KeyVersion:        dto.KeyVersion,
+		CreatedAt:         dto.CreatedAt,
+	}
+}
+
+// VerificationResult is the outcome of the verification of a signature
+// It is meant to be serialized to external services
+type VerificationResult struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason"`
+	// KeyVersion is the version of the device key the signature matches
+	KeyVersion int `json:"key_version,omitempty"`
+}
+
+// SignatureQuery selects a page of the signatures of a device, ordered by counter
+type SignatureQuery struct {
+	// CounterFrom is the counter of the first signature included
+	CounterFrom uint64
+	// CounterTo is the counter of the first signature excluded, zero for no upper bound
+	CounterTo uint64
+	// Offset is the number of matching signatures skipped
+	Offset int
+	// Limit is the maximum number of signatures returned, zero for no limit
+	Limit int
+}
+
+// Matches reports whether the counter of the signature is in the range of the query
+func (q SignatureQuery) Matches(signature SignatureDTO) bool {
+	return signature.Counter >= q.CounterFrom && (q.CounterTo == 0 || signature.Counter < q.CounterTo)
+}
+
+// SignatureListQuery selects a page of signatures of all the devices, ordered by creation
+// time and ID. Empty filters match any signature.
+type SignatureListQuery struct {
+	DeviceID string
+	// CreatedFrom is the first creation time included, zero for no lower bound
+	CreatedFrom time.Time
+	// CreatedTo is the first creation time excluded, zero for no upper bound
+	CreatedTo time.Time
+	// After is the position of the last signature of the previous page, nil for the first page
+	After *SignaturePosition
+	// Limit is the maximum number of signatures returned, zero for no limit
+	Limit int
+}
+
+// SignaturePosition is the position of a signature in the order of a SignatureListQuery
+type SignaturePosition struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Before reports whether the position p comes before the signature in the order of a SignatureListQuery
+func (p SignaturePosition) Before(signature SignatureDTO) bool {
+	if !p.CreatedAt.Equal(signature.CreatedAt) {
+		return p.CreatedAt.Before(signature.CreatedAt)
 	}
+	return p.ID < signature.ID
+}
+
+// Matches reports whether the signature satisfies the filters and follows After
+func (q SignatureListQuery) Matches(signature SignatureDTO) bool {
+	return (q.DeviceID == "" || signature.DeviceID == q.DeviceID) &&
+		(q.CreatedFrom.IsZero() || !signature.CreatedAt.Before(q.CreatedFrom)) &&
+		(q.CreatedTo.IsZero() || signature.CreatedAt.Before(q.CreatedTo)) &&
+		(q.After == nil || q.After.Before(signature))
+}
+
+// ChainVerification is the outcome of the audit of the chain of signatures of a device
+// It is meant to be serialized to external services
+type ChainVerification struct {
+	DeviceID string `json:"device_id"`
+	Valid    bool   `json:"valid"`
+	// SignatureCounter is the signature counter of the device when the audit started
+	SignatureCounter uint64 `json:"signature_counter"`
+	// SignaturesChecked is the number of stored signatures checked
+	SignaturesChecked uint64 `json:"signatures_checked"`
+	// FirstBrokenLink is the first link of the chain failing the audit, nil if the chain is valid
+	FirstBrokenLink *ChainBreak `json:"first_broken_link,omitempty"`
+}
+
+// ChainBreak describes a broken link of the chain of signatures of a device
+type ChainBreak struct {
+	// Counter is the counter at which the chain breaks
+	Counter uint64 `json:"counter"`
+	// SignatureID is the signature breaking the chain, empty if the signature is missing
+	SignatureID string `json:"signature_id,omitempty"`
+	Reason      string `json:"reason"`
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/AloveIs/signing-device-service-go/crypto"
+	"github.com/AloveIs/signing-device-service-go/crypto/hsm"
+	"github.com/AloveIs/signing-device-service-go/persistence"
+)
+
+// Environment variables used to configure the service
+const (
+	// EnvKEKFile is the path of a file containing the key-encryption key (base64, hex or raw)
+	EnvKEKFile = "SIGNING_KEK_FILE"
+	// EnvKEK is the base64 or hex encoded key-encryption key, used if EnvKEKFile is not set
+	EnvKEK = "SIGNING_KEK"
+	// EnvKEKID is the optional identifier of the key-encryption key
+	EnvKEKID = "SIGNING_KEK_ID"
+	// EnvInsecurePlaintextKeys set to "true" lets the service start without a key-encryption
+	// key, storing the private keys in plain. Meant for development only
+	EnvInsecurePlaintextKeys = "SIGNING_INSECURE_PLAINTEXT_KEYS"
+	// EnvPreviousKEKFiles is a comma separated list of files with retired
+	// key-encryption keys, still needed to decrypt keys that have not been re-wrapped
+	EnvPreviousKEKFiles = "SIGNING_PREVIOUS_KEK_FILES"
+	// EnvKeyBackend is the key store of the devices created without choosing one,
+	// "software" (default) or "pkcs11"
+	EnvKeyBackend = "SIGNING_KEY_BACKEND"
+	// EnvPKCS11Module is the path of the PKCS#11 library, setting it enables the pkcs11 backend
+	EnvPKCS11Module = "SIGNING_PKCS11_MODULE"
+	// EnvPKCS11TokenLabel is the label of the PKCS#11 token holding the keys
+	EnvPKCS11TokenLabel = "SIGNING_PKCS11_TOKEN_LABEL"
+	// EnvPKCS11PIN is the user PIN of the PKCS#11 token
+	EnvPKCS11PIN = "SIGNING_PKCS11_PIN"
+	// EnvDeviceIDPattern is a regular expression accepting client supplied device IDs
+	// besides UUIDs, it must match the whole ID
+	EnvDeviceIDPattern = "SIGNING_DEVICE_ID_PATTERN"
+	// EnvDatabase is the database backend storing devices and signatures,
+	// "memory" (default), "journal", "sqlite" or "postgres"
+	EnvDatabase = "SIGNING_DATABASE"
+	// EnvSQLitePath is the path of the SQLite database file, created if it does not exist
+	EnvSQLitePath = "SIGNING_SQLITE_PATH"
+	// EnvPostgresURL is the connection string of the PostgreSQL database
+	EnvPostgresURL = "SIGNING_POSTGRES_URL"
+	// EnvJournalDir is the directory of the journal and its snapshot, created if it does not exist
+	EnvJournalDir = "SIGNING_JOURNAL_DIR"
+	// EnvJournalSnapshotEvery is the number of journal records between two snapshots, 0 disables them
+	EnvJournalSnapshotEvery = "SIGNING_JOURNAL_SNAPSHOT_EVERY"
+)
+
+// Database backends of the service
+const (
+	DatabaseMemory   = "memory"
+	DatabaseJournal  = "journal"
+	DatabaseSQLite   = "sqlite"
+	DatabasePostgres = "postgres"
+
+	defaultJournalDir = "signing-journal"
+	defaultSQLitePath = "signing-device.db"
+)
+
+// Config holds the configuration of the service.
+type Config struct {
+	// KEK encrypts the private keys of the devices at rest, it is required unless
+	// InsecurePlaintextKeys is set
+	KEK *crypto.KEK
+	// InsecurePlaintextKeys stores the private keys in plain when no KEK is configured
+	InsecurePlaintextKeys bool
+	// PreviousKEKs can only decrypt private keys
+	PreviousKEKs []crypto.KEK
+	// KeyBackend is the key store of the devices created without choosing one
+	KeyBackend string
+	// PKCS11 configures the PKCS#11 key store, nil if it is not enabled
+	PKCS11 *hsm.Config
+	// DeviceIDPattern accepts client supplied device IDs besides UUIDs, nil if only UUIDs are accepted
+	DeviceIDPattern *regexp.Regexp
+	// Database is the database backend storing devices and signatures, memory if empty
+	Database string
+	// JournalDir is the directory of the journal backend
+	JournalDir string
+	// JournalSnapshotEvery is the number of records between two snapshots of the journal backend
+	JournalSnapshotEvery int
+	// SQLitePath is the database file of the sqlite backend
+	SQLitePath string
+	// PostgresURL is the connection string of the postgres backend
+	PostgresURL string
+}
+
+// loadConfig reads the configuration from the environment.
+func loadConfig() (Config, error) {
+	var config Config
+
+	kekID := os.Getenv(EnvKEKID)
+	if path := os.Getenv(EnvKEKFile); path != "" {
+		kek, err := crypto.LoadKEKFromFile(kekID, path)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", EnvKEKFile, err)
+		}
+		config.KEK = &kek
+	} else if encoded := os.Getenv(EnvKEK); encoded != "" {
+		kek, err := crypto.ParseKEK(kekID, []byte(encoded))
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", EnvKEK, err)
+		}
+		config.KEK = &kek
+	}
+	if insecure := os.Getenv(EnvInsecurePlaintextKeys); insecure != "" {
+		value, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: value must be true or false", EnvInsecurePlaintextKeys)
+		}
+		config.InsecurePlaintextKeys = value
+	}
+
+	if paths := os.Getenv(EnvPreviousKEKFiles); paths != "" {
+		for _, path := range strings.Split(paths, ",") {
+			kek, err := crypto.LoadKEKFromFile("", strings.TrimSpace(path))
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: %w", EnvPreviousKEKFiles, err)
+			}
+			config.PreviousKEKs = append(config.PreviousKEKs, kek)
+		}
+	}
+
+	if module := os.Getenv(EnvPKCS11Module); module != "" {
+		config.PKCS11 = &hsm.Config{
+			Module:     module,
+			TokenLabel: os.Getenv(EnvPKCS11TokenLabel),
+			PIN:        os.Getenv(EnvPKCS11PIN),
+		}
+	}
+	if pattern := os.Getenv(EnvDeviceIDPattern); pattern != "" {
+		// anchor the pattern to match the whole ID
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", EnvDeviceIDPattern, err)
+		}
+		config.DeviceIDPattern = compiled
+	}
+
+	config.Database = DatabaseMemory
+	if database := os.Getenv(EnvDatabase); database != "" {
+		config.Database = database
+	}
+	switch config.Database {
+	case DatabaseMemory:
+	case DatabaseJournal:
+		config.JournalDir = defaultJournalDir
+		if dir := os.Getenv(EnvJournalDir); dir != "" {
+			config.JournalDir = dir
+		}
+		config.JournalSnapshotEvery = persistence.DefaultSnapshotEvery
+		if every := os.Getenv(EnvJournalSnapshotEvery); every != "" {
+			records, err := strconv.Atoi(every)
+			if err != nil || records < 0 {
+				return Config{}, fmt.Errorf("%s: value must be a non-negative integer", EnvJournalSnapshotEvery)
+			}
+			config.JournalSnapshotEvery = records
+		}
+	case DatabaseSQLite:
+		config.SQLitePath = defaultSQLitePath
+		if path := os.Getenv(EnvSQLitePath); path != "" {
+			config.SQLitePath = path
+		}
+	case DatabasePostgres:
+		config.PostgresURL = os.Getenv(EnvPostgresURL)
+		if config.PostgresURL == "" {
+			return Config{}, fmt.Errorf("%s: the %s database requires %s", EnvDatabase, DatabasePostgres, EnvPostgresURL)
+		}
+	default:
+		return Config{}, fmt.Errorf("%s: value must be of values: %s, %s, %s, %s", EnvDatabase, DatabaseMemory, DatabaseJournal, DatabaseSQLite, DatabasePostgres)
+	}
+
+	config.KeyBackend = crypto.KeyBackendSoftware
+	if backend := os.Getenv(EnvKeyBackend); backend != "" {
+		config.KeyBackend = backend
+	}
+	switch config.KeyBackend {
+	case crypto.KeyBackendSoftware:
+	case hsm.BackendName:
+		if config.PKCS11 == nil {
+			return Config{}, fmt.Errorf("%s: the %s backend requires %s", EnvKeyBackend, hsm.BackendName, EnvPKCS11Module)
+		}
+	default:
+		return Config{}, fmt.Errorf("%s: value must be of values: %s, %s", EnvKeyBackend, crypto.KeyBackendSoftware, hsm.BackendName)
+	}
+	return config, nil
+}
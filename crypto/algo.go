@@ -1,14 +1,28 @@
 package crypto
 
-import "crypto/sha256"
+import (
+	stdcrypto "crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+)
 
 const (
-	AlgoRSA   = "RSA"
-	AlgoECDSA = "ECC"
+	AlgoRSA     = "RSA"
+	AlgoECDSA   = "ECC"
+	AlgoEd25519 = "ED25519"
 )
 
-// computeHash calculates the SHA-256 hash of the input data
-func computeHash(data []byte) []byte {
-	hash := sha256.Sum256(data)
-	return hash[:]
+// ErrInvalidSignature is returned when a signature does not match the data and the key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrInvalidKey is returned when an encoded private key cannot be decoded or is
+// not a valid key of the algorithm.
+var ErrInvalidKey = errors.New("invalid private key")
+
+// computeHash calculates the hash of the input data with the given digest
+func computeHash(data []byte, hash stdcrypto.Hash) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
 }
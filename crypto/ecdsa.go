@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
@@ -14,21 +15,42 @@ type ECCKeyPair struct {
 	Private *ecdsa.PrivateKey
 }
 
-// Sign data with the key
+// Sign data with the key using SHA-256 as digest
 func (keys *ECCKeyPair) Sign(dataToBeSigned []byte) ([]byte, error) {
-	hash := computeHash(dataToBeSigned)
+	return keys.SignWithHash(dataToBeSigned, crypto.SHA256)
+}
+
+// SignWithHash signs data with the key using the given digest
+func (keys *ECCKeyPair) SignWithHash(dataToBeSigned []byte, hash crypto.Hash) ([]byte, error) {
+	digest := computeHash(dataToBeSigned, hash)
 
-	signature, err := ecdsa.SignASN1(rand.Reader, keys.Private, hash[:])
+	signature, err := ecdsa.SignASN1(rand.Reader, keys.Private, digest)
 	if err != nil {
 		return []byte{}, fmt.Errorf("failed to sign data with ECC: %v", err)
 	}
 	return signature, nil
 }
 
+// VerifyWithHash checks an ASN.1 encoded ECDSA signature of data against the public key.
+// Returns ErrInvalidSignature if the signature does not match.
+func (keys *ECCKeyPair) VerifyWithHash(data []byte, signature []byte, hash crypto.Hash) error {
+	digest := computeHash(data, hash)
+	if !ecdsa.VerifyASN1(keys.Public, digest, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
 func (s *ECCKeyPair) GetAlgorithm() string {
 	return AlgoECDSA
 }
 
+// Options returns the parameters of the key pair
+func (s *ECCKeyPair) Options() SignerOptions {
+	params := s.Private.Curve.Params()
+	return SignerOptions{KeySize: params.BitSize, Curve: params.Name}
+}
+
 // ECCMarshaler can encode and decode an ECC key pair.
 type ECCMarshaler struct{}
 
@@ -63,12 +85,26 @@ func (m ECCMarshaler) Marshal(keyPair ECCKeyPair) ([]byte, []byte, error) {
 	return encodedPublic, encodedPrivate, nil
 }
 
-// Decode assembles an ECCKeyPair from an encoded private key.
+// Decode assembles an ECCKeyPair from a PEM encoded private key, SEC 1 or PKCS#8.
+// Returns ErrInvalidKey if the key cannot be decoded or is not a valid ECC key on a supported curve.
 func (m ECCMarshaler) Unmarshal(privateKeyBytes []byte) (*ECCKeyPair, error) {
 	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
 	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("%w: not a SEC 1 or PKCS#8 ECC key: %v", ErrInvalidKey, err)
+		}
+		var ok bool
+		if privateKey, ok = key.(*ecdsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%w: expected an ECC key, got %T", ErrInvalidKey, key)
+		}
+	}
+	if _, has := curves[privateKey.Curve.Params().Name]; !has {
+		return nil, fmt.Errorf("%w: unsupported curve %s", ErrInvalidKey, privateKey.Curve.Params().Name)
 	}
 
 	return &ECCKeyPair{
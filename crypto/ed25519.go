@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Ed25519KeyPair is a DTO that holds Ed25519 private and public keys.
+type Ed25519KeyPair struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// Sign data with the key.
+// Ed25519 hashes the message internally, so the data is signed as it is.
+func (keys *Ed25519KeyPair) Sign(dataToBeSigned []byte) ([]byte, error) {
+	if len(keys.Private) != ed25519.PrivateKeySize {
+		return []byte{}, fmt.Errorf("failed to sign data with Ed25519: invalid private key size %d", len(keys.Private))
+	}
+	return ed25519.Sign(keys.Private, dataToBeSigned), nil
+}
+
+// Verify checks an Ed25519 signature of data against the public key.
+// Returns ErrInvalidSignature if the signature does not match.
+func (keys *Ed25519KeyPair) Verify(data []byte, signature []byte) error {
+	if len(keys.Public) != ed25519.PublicKeySize || !ed25519.Verify(keys.Public, data, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *Ed25519KeyPair) GetAlgorithm() string {
+	return AlgoEd25519
+}
+
+// Options returns the parameters of the key pair
+func (s *Ed25519KeyPair) Options() SignerOptions {
+	return SignerOptions{KeySize: 8 * ed25519.PublicKeySize}
+}
+
+// Ed25519Marshaler can encode and decode an Ed25519 key pair.
+type Ed25519Marshaler struct{}
+
+// NewEd25519Marshaler creates a new Ed25519Marshaler.
+func NewEd25519Marshaler() Ed25519Marshaler {
+	return Ed25519Marshaler{}
+}
+
+// Marshal takes an Ed25519KeyPair and encodes it as PKCS#8 (private key) and
+// PKIX (public key) PEM blocks to be written on disk.
+// It returns the public and the private key as a byte slice.
+func (m Ed25519Marshaler) Marshal(keyPair Ed25519KeyPair) ([]byte, []byte, error) {
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(keyPair.Private)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.Public)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPrivate := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	})
+
+	encodedPublic := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKeyBytes,
+	})
+
+	return encodedPublic, encodedPrivate, nil
+}
+
+// Unmarshal assembles an Ed25519KeyPair from a PKCS#8 PEM encoded private key.
+func (m Ed25519Marshaler) Unmarshal(privateKeyBytes []byte) (*Ed25519KeyPair, error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a PKCS#8 Ed25519 key: %v", ErrInvalidKey, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected an Ed25519 key, got %T", ErrInvalidKey, key)
+	}
+
+	return &Ed25519KeyPair{
+		Private: privateKey,
+		Public:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KEKSize is the size in bytes of a key-encryption key (AES-256)
+const KEKSize = 32
+
+// dekSize is the size in bytes of the data-encryption keys (AES-256)
+const dekSize = 32
+
+// ErrDecryption is returned when a ciphertext cannot be decrypted, because the key
+// is wrong or the data has been tampered with.
+var ErrDecryption = errors.New("decryption failed")
+
+// KEK is a key-encryption key. It protects data with envelope encryption: each
+// piece of data is encrypted with its own random data-encryption key (DEK) and the
+// DEK is in turn encrypted (wrapped) with the KEK. Both layers use AES-GCM.
+type KEK struct {
+	// ID identifies the KEK, it is stored next to the data it protects
+	ID  string
+	key []byte
+}
+
+// NewKEK creates a KEK from a 32 bytes key. If id is empty the ID is derived
+// from the fingerprint of the key.
+func NewKEK(id string, key []byte) (KEK, error) {
+	if len(key) != KEKSize {
+		return KEK{}, fmt.Errorf("invalid key-encryption key: expected %d bytes, got %d", KEKSize, len(key))
+	}
+	if id == "" {
+		fingerprint := sha256.Sum256(key)
+		id = hex.EncodeToString(fingerprint[:8])
+	}
+	return KEK{ID: id, key: append([]byte{}, key...)}, nil
+}
+
+// ParseKEK creates a KEK from a base64 or hex encoded key, surrounding whitespace is ignored.
+// Returns an error if data is not a valid encoding of a 32 bytes key.
+func ParseKEK(id string, data []byte) (KEK, error) {
+	encoded := strings.TrimSpace(string(data))
+	// a hex encoded key is also valid base64, of another length
+	if len(encoded) == hex.EncodedLen(KEKSize) {
+		if key, err := hex.DecodeString(encoded); err == nil {
+			return NewKEK(id, key)
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return KEK{}, fmt.Errorf("invalid key-encryption key: expected a base64 or hex encoded key: %w", err)
+	}
+	return NewKEK(id, key)
+}
+
+// LoadKEKFromFile reads a KEK from a file containing the key, base64 or hex encoded, or
+// raw. A file of exactly 32 bytes holds the raw key, no encoding of the key has that length.
+func LoadKEKFromFile(id string, path string) (KEK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return KEK{}, err
+	}
+	if len(data) == KEKSize {
+		return NewKEK(id, data)
+	}
+	return ParseKEK(id, data)
+}
+
+// GenerateKEK creates a new random KEK.
+func GenerateKEK(id string) (KEK, error) {
+	key := make([]byte, KEKSize)
+	if _, err := rand.Read(key); err != nil {
+		return KEK{}, err
+	}
+	return NewKEK(id, key)
+}
+
+// Seal encrypts plaintext with a new DEK and wraps the DEK with the KEK.
+// The additional data aad is authenticated but not encrypted, it must be passed
+// unchanged to Open. Returns the ciphertext and the wrapped DEK.
+func (k KEK) Seal(plaintext []byte, aad []byte) ([]byte, []byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := sealAESGCM(dek, plaintext, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedDEK, err := k.Wrap(dek, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, wrappedDEK, nil
+}
+
+// Open unwraps the DEK with the KEK and decrypts ciphertext with it.
+// Returns ErrDecryption if the KEK is wrong or the data has been modified.
+func (k KEK) Open(ciphertext []byte, wrappedDEK []byte, aad []byte) ([]byte, error) {
+	dek, err := k.Unwrap(wrappedDEK, aad)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(dek, ciphertext, aad)
+}
+
+// Wrap encrypts a DEK with the KEK.
+func (k KEK) Wrap(dek []byte, aad []byte) ([]byte, error) {
+	return sealAESGCM(k.key, dek, aad)
+}
+
+// Unwrap decrypts a DEK wrapped with the KEK.
+// Returns ErrDecryption if the KEK is wrong or the wrapped DEK has been modified.
+func (k KEK) Unwrap(wrappedDEK []byte, aad []byte) ([]byte, error) {
+	return openAESGCM(k.key, wrappedDEK, aad)
+}
+
+// sealAESGCM encrypts plaintext with AES-GCM, the random nonce is prepended to the ciphertext.
+func sealAESGCM(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openAESGCM decrypts a ciphertext produced by sealAESGCM.
+func openAESGCM(key []byte, ciphertext []byte, aad []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecryption)
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+	return plaintext, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test envelope encryption with a KEK
+//   - sealed data can be opened with the same KEK and additional data
+//   - a different KEK or additional data cannot open it
+//   - a DEK can be re-wrapped under another KEK without decrypting the data
+func TestKEKSealOpen(t *testing.T) {
+	kek, err := GenerateKEK("")
+	if err != nil {
+		t.Fatalf("Failed to generate KEK: %v", err)
+	}
+	if kek.ID == "" {
+		t.Error("Expected KEK ID to be derived from the key")
+	}
+
+	plaintext := []byte("private key")
+	aad := []byte("device-id")
+	ciphertext, wrappedDEK, err := kek.Seal(plaintext, aad)
+	if err != nil {
+		t.Fatalf("Failed to seal data: %v", err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("Ciphertext contains the plaintext")
+	}
+
+	opened, err := kek.Open(ciphertext, wrappedDEK, aad)
+	if err != nil {
+		t.Fatalf("Failed to open data: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, opened)
+	}
+
+	if _, err := kek.Open(ciphertext, wrappedDEK, []byte("other-device")); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption with wrong additional data, got %v", err)
+	}
+
+	otherKEK, err := GenerateKEK("other")
+	if err != nil {
+		t.Fatalf("Failed to generate KEK: %v", err)
+	}
+	if _, err := otherKEK.Open(ciphertext, wrappedDEK, aad); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption with wrong KEK, got %v", err)
+	}
+
+	// re-wrap the DEK under the other KEK
+	dek, err := kek.Unwrap(wrappedDEK, aad)
+	if err != nil {
+		t.Fatalf("Failed to unwrap DEK: %v", err)
+	}
+	rewrappedDEK, err := otherKEK.Wrap(dek, aad)
+	if err != nil {
+		t.Fatalf("Failed to wrap DEK: %v", err)
+	}
+	if opened, err := otherKEK.Open(ciphertext, rewrappedDEK, aad); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Failed to open data with re-wrapped DEK: %v", err)
+	}
+}
+
+// Test parsing a KEK from its encodings
+//   - base64 and hex encoded keys, and raw keys in files, are accepted
+//   - malformed encodings and keys of the wrong size are rejected
+func TestParseKEK(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KEKSize)
+	testCases := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"base64", "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=\n", true},
+		{"hex", strings.Repeat("07", KEKSize) + "\n", true},
+		{"malformed base64", "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHB!c=", false},
+		{"malformed hex", strings.Repeat("0g", KEKSize), false},
+		{"short base64", "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=", false},
+		{"raw", string(key), false},
+		{"too short", "too short", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kek, err := ParseKEK("", []byte(tc.data))
+			if !tc.valid {
+				if err == nil {
+					t.Error("Expected error for an invalid key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse KEK: %v", err)
+			}
+			if !bytes.Equal(kek.key, key) {
+				t.Errorf("Expected key %x, got %x", key, kek.key)
+			}
+		})
+	}
+
+	// files may hold the raw key
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kek, err := LoadKEKFromFile("id", path)
+	if err != nil {
+		t.Fatalf("Failed to load raw KEK: %v", err)
+	}
+	if kek.ID != "id" || !bytes.Equal(kek.key, key) {
+		t.Error("Raw KEK does not match")
+	}
+}
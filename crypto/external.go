@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+)
+
+// ExternalSigner signs with a private key held outside of the process (e.g. in a
+// hardware security module), reached through the standard crypto.Signer interface.
+// The digest is computed in software, only the signature primitive is delegated.
+// Verification only needs the public key and is performed in software too.
+type ExternalSigner struct {
+	key stdcrypto.Signer
+	// RSA keys
+	scheme rsaScheme
+	// ECC keys
+	hash stdcrypto.Hash
+}
+
+// NewExternalSigner creates a signer for key with the scheme and digest selected by options.
+// Only RSA and ECC keys are supported.
+func NewExternalSigner(key stdcrypto.Signer, options SignerOptions) (*ExternalSigner, error) {
+	s := &ExternalSigner{key: key}
+	var err error
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		s.scheme, err = options.rsaScheme(public.N.BitLen())
+	case *ecdsa.PublicKey:
+		s.hash, err = options.ecdsaHash(public.Curve)
+	default:
+		return nil, fmt.Errorf("%w: external key of type %T", ErrUnsupportedAlgorithm, public)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sign data with the signature scheme and the digest of the signer
+func (s *ExternalSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	var opts stdcrypto.SignerOpts
+	hash := s.digest()
+	if s.scheme.name == SchemePSS {
+		opts = &rsa.PSSOptions{SaltLength: s.scheme.saltLength, Hash: hash}
+	} else {
+		opts = hash
+	}
+	signature, err := s.key.Sign(rand.Reader, computeHash(dataToBeSigned, hash), opts)
+	if err != nil {
+		return []byte{}, fmt.Errorf("failed to sign data with external %s key: %v", s.GetAlgorithm(), err)
+	}
+	return signature, nil
+}
+
+// Verify a signature with the public key of the signer
+func (s *ExternalSigner) Verify(data []byte, signature []byte) error {
+	switch public := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		keys := &RSAKeyPair{Public: public}
+		if s.scheme.name == SchemePSS {
+			return keys.VerifyPSS(data, signature, s.digest(), s.scheme.saltLength)
+		}
+		return keys.VerifyPKCS1v15(data, signature, s.digest())
+	case *ecdsa.PublicKey:
+		keys := &ECCKeyPair{Public: public}
+		return keys.VerifyWithHash(data, signature, s.digest())
+	}
+	return ErrInvalidSignature
+}
+
+func (s *ExternalSigner) GetAlgorithm() string {
+	if _, ok := s.key.Public().(*ecdsa.PublicKey); ok {
+		return AlgoECDSA
+	}
+	return AlgoRSA
+}
+
+// PublicKey returns the public key of the signer as a PKIX PEM block
+func (s *ExternalSigner) PublicKey() string {
+	public, err := encodePublicKey(s.key.Public())
+	if err != nil {
+		// TODO: handle this
+		panic(err)
+	}
+	return public
+}
+
+// Options returns the key parameters, the signature scheme and the digest of the signer
+func (s *ExternalSigner) Options() SignerOptions {
+	switch public := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		options := SignerOptions{
+			KeySize: public.N.BitLen(),
+			Scheme:  SchemePKCS1v15,
+			Hash:    s.digest().String(),
+		}
+		if s.scheme.name == SchemePSS {
+			options.Scheme = SchemePSS
+			options.SaltLength = SaltLengthOption(s.scheme.saltLength)
+		}
+		return options
+	case *ecdsa.PublicKey:
+		params := public.Curve.Params()
+		return SignerOptions{KeySize: params.BitSize, Curve: params.Name, Hash: s.digest().String()}
+	}
+	return SignerOptions{}
+}
+
+// Marshal returns the public key only, the private key never leaves its store
+func (s *ExternalSigner) Marshal() ([]byte, []byte, error) {
+	public, err := encodePublicKey(s.key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(public), nil, nil
+}
+
+// digest returns the hash of the signer, the default digest of its algorithm if none has been set
+func (s *ExternalSigner) digest() stdcrypto.Hash {
+	if s.hash != 0 {
+		return s.hash
+	}
+	if s.scheme.hash != 0 {
+		return s.scheme.hash
+	}
+	switch public := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return defaultHash(AlgoRSA, public.N.BitLen())
+	case *ecdsa.PublicKey:
+		return defaultHash(AlgoECDSA, public.Curve.Params().BitSize)
+	}
+	return 0
+}
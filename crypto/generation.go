@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -9,14 +10,20 @@ import (
 )
 
 // RSAGenerator generates a RSA key pair.
-type RSAGenerator struct{}
+type RSAGenerator struct {
+	// Bits is the size of the modulus, DefaultRSAKeySize if zero
+	Bits int
+}
 
 var ErrUnsupportedAlgorithm = fmt.Errorf("unsupported algorithm")
 
 // Generate generates a new RSAKeyPair.
 func (g *RSAGenerator) Generate() (*RSAKeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := rsa.GenerateKey(rand.Reader, 512)
+	bits := g.Bits
+	if bits == 0 {
+		bits = DefaultRSAKeySize
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return nil, err
 	}
@@ -28,12 +35,18 @@ func (g *RSAGenerator) Generate() (*RSAKeyPair, error) {
 }
 
 // ECCGenerator generates an ECC key pair.
-type ECCGenerator struct{}
+type ECCGenerator struct {
+	// Curve of the key, DefaultCurve if nil
+	Curve elliptic.Curve
+}
 
 // Generate generates a new ECCKeyPair.
 func (g *ECCGenerator) Generate() (*ECCKeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	curve := g.Curve
+	if curve == nil {
+		curve = curves[DefaultCurve]
+	}
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		return nil, err
 	}
@@ -43,3 +56,19 @@ func (g *ECCGenerator) Generate() (*ECCKeyPair, error) {
 		Private: key,
 	}, nil
 }
+
+// Ed25519Generator generates an Ed25519 key pair.
+type Ed25519Generator struct{}
+
+// Generate generates a new Ed25519KeyPair.
+func (g *Ed25519Generator) Generate() (*Ed25519KeyPair, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ed25519KeyPair{
+		Public:  public,
+		Private: private,
+	}, nil
+}
@@ -0,0 +1,35 @@
+// Package hsm provides a crypto.KeyStore backed by a PKCS#11 module, so that the
+// private keys of the devices are generated and used inside a hardware security
+// module and never leave it. SoftHSM2 can be used to run it without hardware.
+//
+// The PKCS#11 implementation requires cgo and is only compiled with the pkcs11
+// build tag (go build -tags pkcs11), otherwise Open returns ErrNotSupported.
+package hsm
+
+import (
+	"errors"
+
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// BackendName is the key backend name devices use to select the PKCS#11 key store
+const BackendName = "pkcs11"
+
+var (
+	// ErrNotSupported is returned by Open when the binary is built without PKCS#11 support.
+	ErrNotSupported = errors.New("PKCS#11 support not compiled in, build with -tags pkcs11")
+	// ErrKeyNotFound is returned when no key pair is stored under a label.
+	ErrKeyNotFound = errors.New("key not found in token")
+)
+
+var _ crypto.KeyStore = (*KeyStore)(nil)
+
+// Config selects the token holding the keys.
+type Config struct {
+	// Module is the path of the PKCS#11 shared library (e.g. /usr/lib/softhsm/libsofthsm2.so)
+	Module string
+	// TokenLabel is the label of the token the keys are stored in
+	TokenLabel string
+	// PIN is the user PIN of the token
+	PIN string
+}
@@ -0,0 +1,429 @@
+//go:build pkcs11
+
+package hsm
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/AloveIs/signing-device-service-go/crypto"
+	"github.com/miekg/pkcs11"
+)
+
+// KeyStore generates and uses keys in a PKCS#11 token.
+// It is safe for concurrent use, the operations are serialized on a single session.
+type KeyStore struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	// mutex guards the session and the key cache, PKCS#11 sessions are not thread-safe
+	mutex sync.Mutex
+	// keys caches the keys already found in the token, by label
+	keys map[string]*privateKey
+}
+
+// Open loads the PKCS#11 module, opens a session on the token and logs in.
+func Open(config Config) (*KeyStore, error) {
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load PKCS#11 module %s", config.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !isError(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("cannot initialize PKCS#11 module %s: %w", config.Module, err)
+	}
+	slot, err := findSlot(ctx, config.TokenLabel)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, fmt.Errorf("cannot open a session on token %s: %w", config.TokenLabel, err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, config.PIN); err != nil && !isError(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, fmt.Errorf("cannot log in token %s: %w", config.TokenLabel, err)
+	}
+	return &KeyStore{
+		ctx:     ctx,
+		session: session,
+		keys:    make(map[string]*privateKey),
+	}, nil
+}
+
+// findSlot returns the slot of the token labeled tokenLabel.
+func findSlot(ctx *pkcs11.Ctx, tokenLabel string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("cannot list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		// labels are padded with blanks to 32 characters
+		if strings.TrimRight(info.Label, " \x00") == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("PKCS#11 token %q not found", tokenLabel)
+}
+
+// Close logs out and releases the module.
+func (k *KeyStore) Close() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.ctx.Logout(k.session)
+	k.ctx.CloseSession(k.session)
+	err := k.ctx.Finalize()
+	k.ctx.Destroy()
+	k.keys = nil
+	return err
+}
+
+// curveOIDs maps the supported curves to their object identifier
+var curveOIDs = map[string]asn1.ObjectIdentifier{
+	"P-256": {1, 2, 840, 10045, 3, 1, 7},
+	"P-384": {1, 3, 132, 0, 34},
+	"P-521": {1, 3, 132, 0, 35},
+}
+
+// Generate creates a new RSA or ECC key pair in the token, stored under label.
+// The private key is sensitive and cannot be extracted.
+func (k *KeyStore) Generate(algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	var mechanism uint
+	var publicTemplate []*pkcs11.Attribute
+	switch algorithm {
+	case crypto.AlgoRSA:
+		bits, err := options.RSAKeySize()
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		publicTemplate = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		}
+	case crypto.AlgoECDSA:
+		curve, err := options.ECCCurve()
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(curveOIDs[curve.Params().Name])
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+		publicTemplate = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		}
+	default:
+		return nil, fmt.Errorf("%w: algorithm: %s keys are not supported by the %s backend", crypto.ErrInvalidOptions, algorithm, BackendName)
+	}
+	publicTemplate = append(publicTemplate,
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(label)),
+	)
+	privateTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(label)),
+	}
+
+	k.mutex.Lock()
+	key, err := k.generate(mechanism, publicTemplate, privateTemplate)
+	if err == nil {
+		k.keys[label] = key
+	}
+	k.mutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate %s key %s: %w", algorithm, label, err)
+	}
+
+	signer, err := crypto.NewExternalSigner(key, options)
+	if err != nil {
+		k.Delete(label)
+		return nil, err
+	}
+	return signer, nil
+}
+
+// generate creates the key pair and reads its public key, the caller must hold the mutex.
+func (k *KeyStore) generate(mechanism uint, publicTemplate, privateTemplate []*pkcs11.Attribute) (*privateKey, error) {
+	public, private, err := k.ctx.GenerateKeyPair(k.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, publicTemplate, privateTemplate)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := k.readPublicKey(public)
+	if err != nil {
+		k.ctx.DestroyObject(k.session, public)
+		k.ctx.DestroyObject(k.session, private)
+		return nil, err
+	}
+	return &privateKey{store: k, handle: private, public: publicKey}, nil
+}
+
+// Load returns the signer of the key pair stored under label.
+// Returns ErrKeyNotFound if the token has no such key.
+func (k *KeyStore) Load(algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	key, err := k.findKey(label)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := crypto.NewExternalSigner(key, options)
+	if err != nil {
+		return nil, err
+	}
+	if signer.GetAlgorithm() != algorithm {
+		return nil, fmt.Errorf("key %s is not an %s key", label, algorithm)
+	}
+	return signer, nil
+}
+
+// findKey returns the private key stored under label, looking it up in the token
+// the first time it is requested.
+func (k *KeyStore) findKey(label string) (*privateKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if key, has := k.keys[label]; has {
+		return key, nil
+	}
+	private, err := k.findObject(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	public, err := k.findObject(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := k.readPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	key := &privateKey{store: k, handle: private, public: publicKey}
+	k.keys[label] = key
+	return key, nil
+}
+
+// Delete destroys the key pair stored under label.
+func (k *KeyStore) Delete(label string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	delete(k.keys, label)
+	for _, class := range []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY} {
+		object, err := k.findObject(class, label)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := k.ctx.DestroyObject(k.session, object); err != nil {
+			return fmt.Errorf("cannot delete key %s: %w", label, err)
+		}
+	}
+	return nil
+}
+
+// findObject returns the object of class stored under label, the caller must hold the mutex.
+func (k *KeyStore) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := k.ctx.FindObjectsInit(k.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := k.ctx.FindObjects(k.session, 1)
+	if finalErr := k.ctx.FindObjectsFinal(k.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+	}
+	return objects[0], nil
+}
+
+// readPublicKey reads an RSA or ECC public key object, the caller must hold the mutex.
+func (k *KeyStore) readPublicKey(object pkcs11.ObjectHandle) (stdcrypto.PublicKey, error) {
+	attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyType := bytesToUint(attributes[0].Value)
+
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attributes[0].Value),
+			E: int(new(big.Int).SetBytes(attributes[1].Value).Int64()),
+		}, nil
+	case pkcs11.CKK_EC:
+		attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return parseECPublicKey(attributes[0].Value, attributes[1].Value)
+	}
+	return nil, fmt.Errorf("unsupported PKCS#11 key type %d", keyType)
+}
+
+// parseECPublicKey decodes the DER encoded curve OID and point of an EC key.
+func parseECPublicKey(params []byte, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("invalid EC parameters: %w", err)
+	}
+	var curve elliptic.Curve
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		if oid.Equal(curveOIDs[c.Params().Name]) {
+			curve = c
+		}
+	}
+	if curve == nil {
+		return nil, fmt.Errorf("unsupported curve %s", oid)
+	}
+	// the point is wrapped in an OCTET STRING
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil {
+		raw = point
+	}
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		return nil, errors.New("invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// privateKey is a key pair in the token, it implements the standard crypto.Signer interface.
+type privateKey struct {
+	store  *KeyStore
+	handle pkcs11.ObjectHandle
+	public stdcrypto.PublicKey
+}
+
+func (key *privateKey) Public() stdcrypto.PublicKey {
+	return key.public
+}
+
+// digestInfoPrefixes are the DER encoded DigestInfo headers of PKCS#1 v1.5 signatures
+var digestInfoPrefixes = map[stdcrypto.Hash][]byte{
+	stdcrypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	stdcrypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	stdcrypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pssMechanisms maps the digests to their PKCS#11 hash and mask generation mechanisms
+var pssMechanisms = map[stdcrypto.Hash][2]uint{
+	stdcrypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	stdcrypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	stdcrypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// Sign a digest in the token. RSA keys use PKCS#1 v1.5, or PSS if opts is *rsa.PSSOptions,
+// ECC signatures are returned ASN.1 encoded as with ecdsa.SignASN1.
+func (key *privateKey) Sign(_ io.Reader, digest []byte, opts stdcrypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			mechanisms, has := pssMechanisms[hash]
+			if !has {
+				return nil, fmt.Errorf("unsupported digest %s", hash)
+			}
+			saltLength := pss.SaltLength
+			if saltLength <= 0 {
+				saltLength = hash.Size()
+			}
+			params := pkcs11.NewPSSParams(mechanisms[0], mechanisms[1], uint(saltLength))
+			return key.store.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), key.handle, digest)
+		}
+		prefix, has := digestInfoPrefixes[hash]
+		if !has {
+			return nil, fmt.Errorf("unsupported digest %s", hash)
+		}
+		digestInfo := append(append([]byte{}, prefix...), digest...)
+		return key.store.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), key.handle, digestInfo)
+	case *ecdsa.PublicKey:
+		signature, err := key.store.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), key.handle, digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11 returns r and s concatenated
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(signature[:half]),
+			S: new(big.Int).SetBytes(signature[half:]),
+		})
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key.public)
+}
+
+// sign performs a single signature operation in the token.
+func (k *KeyStore) sign(mechanism *pkcs11.Mechanism, key pkcs11.ObjectHandle, data []byte) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mechanism}, key); err != nil {
+		return nil, err
+	}
+	return k.ctx.Sign(k.session, data)
+}
+
+// isError tells if err is the PKCS#11 return value code.
+func isError(err error, code pkcs11.Error) bool {
+	var p11Err pkcs11.Error
+	return errors.As(err, &p11Err) && p11Err == code
+}
+
+// bytesToUint decodes a CK_ULONG attribute, stored in native (little endian) byte order.
+func bytesToUint(value []byte) uint {
+	var n uint
+	for i := len(value) - 1; i >= 0; i-- {
+		n = n<<8 | uint(value[i])
+	}
+	return n
+}
@@ -0,0 +1,29 @@
+//go:build !pkcs11
+
+package hsm
+
+import "github.com/AloveIs/signing-device-service-go/crypto"
+
+// KeyStore is not available without the pkcs11 build tag.
+type KeyStore struct{}
+
+// Open always returns ErrNotSupported, the binary is built without PKCS#11 support.
+func Open(config Config) (*KeyStore, error) {
+	return nil, ErrNotSupported
+}
+
+func (k *KeyStore) Generate(algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	return nil, ErrNotSupported
+}
+
+func (k *KeyStore) Load(algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	return nil, ErrNotSupported
+}
+
+func (k *KeyStore) Delete(label string) error {
+	return ErrNotSupported
+}
+
+func (k *KeyStore) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+//go:build pkcs11
+
+package hsm
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// softHSMModules are the usual install locations of the SoftHSM2 library
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+}
+
+// openSoftHSM initializes a fresh SoftHSM2 token in a temporary directory and opens it.
+// The test is skipped if SoftHSM2 is not installed, or fails on CI (CI=true) where it must be.
+// SOFTHSM2_MODULE overrides the library path.
+func openSoftHSM(t *testing.T) *KeyStore {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		for _, path := range softHSMModules {
+			if _, err := os.Stat(path); err == nil {
+				module = path
+				break
+			}
+		}
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if module == "" || err != nil {
+		if ci, _ := strconv.ParseBool(os.Getenv("CI")); ci {
+			t.Fatal("SoftHSM2 is not installed, it is required on CI")
+		}
+		t.Skip("SoftHSM2 is not installed")
+	}
+
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	out, err := exec.Command(util, "--init-token", "--free", "--label", "test", "--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to initialize token: %v: %s", err, out)
+	}
+
+	store, err := Open(Config{Module: module, TokenLabel: "test", PIN: "1234"})
+	if err != nil {
+		t.Fatalf("Failed to open token: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Test the signers of keys generated in the token
+//   - signatures verify in software against the public key
+//   - keys can be loaded back by label and deleted
+func TestKeyStore(t *testing.T) {
+	store := openSoftHSM(t)
+
+	testCases := []struct {
+		name    string
+		algo    string
+		options crypto.SignerOptions
+	}{
+		{"RSA", crypto.AlgoRSA, crypto.SignerOptions{}},
+		{"RSA PSS", crypto.AlgoRSA, crypto.SignerOptions{Scheme: crypto.SchemePSS, Hash: "SHA-384"}},
+		{"ECC P-256", crypto.AlgoECDSA, crypto.SignerOptions{Curve: "P-256"}},
+		{"ECC P-384", crypto.AlgoECDSA, crypto.SignerOptions{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := store.Generate(tc.algo, tc.options, tc.name)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			_, private, err := signer.Marshal()
+			if err != nil || private != nil {
+				t.Errorf("Expected no private key to be marshalled, got %d bytes (%v)", len(private), err)
+			}
+
+			data := []byte("data")
+			signature, err := signer.Sign(data)
+			if err != nil {
+				t.Fatalf("Failed to sign data: %v", err)
+			}
+			if err := signer.Verify(data, signature); err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+
+			// drop the cache to read the key back from the token
+			store.mutex.Lock()
+			delete(store.keys, tc.name)
+			store.mutex.Unlock()
+			loaded, err := store.Load(tc.algo, signer.Options(), tc.name)
+			if err != nil {
+				t.Fatalf("Failed to load key: %v", err)
+			}
+			if loaded.PublicKey() != signer.PublicKey() {
+				t.Error("Expected the loaded public key to match")
+			}
+			if err := loaded.Verify(data, signature); err != nil {
+				t.Errorf("Expected valid signature with the loaded key, got %v", err)
+			}
+
+			if err := store.Delete(tc.name); err != nil {
+				t.Fatalf("Failed to delete key: %v", err)
+			}
+			if _, err := store.Load(tc.algo, signer.Options(), tc.name); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected ErrKeyNotFound, got %v", err)
+			}
+		})
+	}
+
+	if _, err := store.Generate(crypto.AlgoEd25519, crypto.SignerOptions{}, "ed25519"); !errors.Is(err, crypto.ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions for Ed25519, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// JWK is the JSON Web Key (RFC 7517) representation of a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Elliptic curve (EC and OKP) public key parameters
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// NewJWK builds the JWK of a PKIX PEM public key, as returned by Signer.PublicKey,
+// identified by kid. The "alg" member is set when the algorithm and the options the key
+// signs with map onto a JOSE algorithm (RFC 7518, RFC 8037).
+func NewJWK(publicKeyPEM string, options SignerOptions, kid string) (JWK, error) {
+	public, _, err := DecodePublicKey(publicKeyPEM)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Kid: kid, Use: "sig"}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBase64URL(key.N.Bytes())
+		jwk.E = encodeBase64URL(big.NewInt(int64(key.E)).Bytes())
+		if hash, err := parseHash(options.Hash); err == nil {
+			prefix := "RS"
+			if options.Scheme == SchemePSS {
+				prefix = "PS"
+			}
+			jwk.Alg = joseAlgorithm(prefix, hash)
+		}
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = params.Name
+		jwk.X = encodeBase64URL(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeBase64URL(key.Y.FillBytes(make([]byte, size)))
+		// JOSE pairs each curve with a single digest (e.g. ES384 is P-384 with SHA-384)
+		if hash, err := parseHash(options.Hash); err == nil && curveHash(params.BitSize) == hash {
+			jwk.Alg = joseAlgorithm("ES", hash)
+		}
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBase64URL(key)
+		jwk.Alg = "EdDSA"
+	default:
+		return JWK{}, fmt.Errorf("%w: cannot represent %T as JWK", ErrUnsupportedAlgorithm, public)
+	}
+	return jwk, nil
+}
+
+// joseAlgorithm returns the JOSE name of a signature algorithm family with hash (e.g. RS256)
+func joseAlgorithm(prefix string, hash stdcrypto.Hash) string {
+	return prefix + strings.TrimPrefix(hash.String(), "SHA-")
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
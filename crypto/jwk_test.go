@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+// Test the JWK representation of the built-in signers
+//   - key type, curve and JOSE algorithm follow the signer options
+//   - the encoded parameters match the public key
+func TestNewJWK(t *testing.T) {
+	testCases := []struct {
+		name    string
+		algo    string
+		options SignerOptions
+		kty     string
+		crv     string
+		alg     string
+	}{
+		{"RSA", AlgoRSA, SignerOptions{}, "RSA", "", "RS256"},
+		{"RSA PSS", AlgoRSA, SignerOptions{Scheme: SchemePSS, Hash: "SHA-512"}, "RSA", "", "PS512"},
+		{"ECC", AlgoECDSA, SignerOptions{}, "EC", "P-384", "ES384"},
+		{"ECC mismatched digest", AlgoECDSA, SignerOptions{Curve: "P-256", Hash: "SHA-512"}, "EC", "P-256", ""},
+		{"Ed25519", AlgoEd25519, SignerOptions{}, "OKP", "Ed25519", "EdDSA"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			algorithm, err := Lookup(tc.algo)
+			if err != nil {
+				t.Fatalf("Failed to lookup algorithm: %v", err)
+			}
+			signer, err := algorithm.New(tc.options)
+			if err != nil {
+				t.Fatalf("Failed to create signer: %v", err)
+			}
+			jwk, err := NewJWK(signer.PublicKey(), signer.Options(), "kid")
+			if err != nil {
+				t.Fatalf("Failed to create JWK: %v", err)
+			}
+			if jwk.Kty != tc.kty || jwk.Crv != tc.crv || jwk.Alg != tc.alg || jwk.Kid != "kid" {
+				t.Errorf("Expected kty=%s crv=%s alg=%s, got %+v", tc.kty, tc.crv, tc.alg, jwk)
+			}
+
+			public, _, err := DecodePublicKey(signer.PublicKey())
+			if err != nil {
+				t.Fatalf("Failed to decode public key: %v", err)
+			}
+			switch key := public.(type) {
+			case *rsa.PublicKey:
+				if decodeBigInt(t, jwk.N).Cmp(key.N) != 0 || decodeBigInt(t, jwk.E).Int64() != int64(key.E) {
+					t.Error("RSA JWK parameters do not match the public key")
+				}
+			case *ecdsa.PublicKey:
+				if decodeBigInt(t, jwk.X).Cmp(key.X) != 0 || decodeBigInt(t, jwk.Y).Cmp(key.Y) != 0 {
+					t.Error("EC JWK parameters do not match the public key")
+				}
+			}
+		})
+	}
+}
+
+func decodeBigInt(t *testing.T, value string) *big.Int {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("Failed to decode %q: %v", value, err)
+	}
+	return new(big.Int).SetBytes(data)
+}
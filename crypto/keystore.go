@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// KeyBackendSoftware is the name of the built-in backend that keeps the keys in
+// process memory and lets them be marshalled with the device.
+const KeyBackendSoftware = "software"
+
+// ErrUnknownKeyBackend is returned when looking up a key store that has not been registered.
+var ErrUnknownKeyBackend = errors.New("unknown key backend")
+
+// KeyStore generates and uses keys that never leave an external module (e.g. an HSM).
+// The signers it returns marshal only their public key, the private key is
+// referenced by its label.
+type KeyStore interface {
+	// Generate creates a new key pair for algorithm with the given options, stored under label.
+	// Options that are not supported must be rejected with ErrInvalidOptions
+	Generate(algorithm string, options SignerOptions, label string) (MarshallableSigner, error)
+	// Load returns the signer of the key pair stored under label
+	Load(algorithm string, options SignerOptions, label string) (MarshallableSigner, error)
+	// Delete destroys the key pair stored under label
+	Delete(label string) error
+}
+
+var (
+	keyStoresMutex sync.RWMutex
+	keyStores      = make(map[string]KeyStore)
+)
+
+// RegisterKeyStore makes a key store available to devices under the backend name.
+// Registering a name twice replaces the previous key store.
+func RegisterKeyStore(name string, store KeyStore) error {
+	if name == "" || name == KeyBackendSoftware || store == nil {
+		return fmt.Errorf("invalid key store %q", name)
+	}
+	keyStoresMutex.Lock()
+	defer keyStoresMutex.Unlock()
+	keyStores[name] = store
+	return nil
+}
+
+// LookupKeyStore returns the key store registered under the backend name.
+// Returns ErrUnknownKeyBackend if no such key store exists.
+func LookupKeyStore(name string) (KeyStore, error) {
+	keyStoresMutex.RLock()
+	defer keyStoresMutex.RUnlock()
+	store, has := keyStores[name]
+	if !has {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyBackend, name)
+	}
+	return store, nil
+}
+
+// KeyBackends returns the sorted names of the available key backends, software included.
+func KeyBackends() []string {
+	keyStoresMutex.RLock()
+	defer keyStoresMutex.RUnlock()
+	names := []string{KeyBackendSoftware}
+	for name := range keyStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,257 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidOptions is returned when a signer is configured with unsupported options.
+var ErrInvalidOptions = errors.New("invalid signer options")
+
+const (
+	// SchemePKCS1v15 is the RSASSA-PKCS1-v1_5 signature scheme
+	SchemePKCS1v15 = "PKCS1v15"
+	// SchemePSS is the RSASSA-PSS signature scheme
+	SchemePSS = "PSS"
+)
+
+const (
+	// DefaultRSAKeySize is the RSA modulus size used when none is specified
+	DefaultRSAKeySize = 2048
+	// DefaultCurve is the elliptic curve used when none is specified
+	DefaultCurve = "P-384"
+	// DefaultRSAHash is the digest used by RSA keys when none is specified
+	DefaultRSAHash = stdcrypto.SHA256
+)
+
+// SignerOptions configures the key pair and the signature scheme of a signer.
+// Zero values select the defaults of the algorithm.
+type SignerOptions struct {
+	// KeySize is the size of the key in bits. It can only be chosen for RSA keys,
+	// for the other algorithms it is derived from the key.
+	KeySize int
+	// Curve is the name of the elliptic curve (e.g. "P-256"), only for ECC keys
+	Curve string
+	// Scheme is the RSA signature scheme, SchemePKCS1v15 (default) or SchemePSS
+	Scheme string
+	// SaltLength is the RSA-PSS salt length in bytes, nil selects the digest size
+	SaltLength *int
+	// Hash is the name of the digest applied before signing (e.g. "SHA-256").
+	// RSA keys default to SHA-256, ECC keys to the digest matching the curve size.
+	Hash string
+}
+
+// rsaKeySizes lists the supported RSA modulus sizes in bits
+var rsaKeySizes = []int{2048, 3072, 4096}
+
+// hashes maps the supported digest names to their implementation
+var hashes = map[string]stdcrypto.Hash{
+	stdcrypto.SHA256.String(): stdcrypto.SHA256,
+	stdcrypto.SHA384.String(): stdcrypto.SHA384,
+	stdcrypto.SHA512.String(): stdcrypto.SHA512,
+}
+
+// curves maps the supported curve names to their implementation
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// RSAKeySize validates the options of an RSA key and returns the modulus size to use.
+func (o SignerOptions) RSAKeySize() (int, error) {
+	if o.Curve != "" {
+		return 0, fmt.Errorf("%w: curve: not supported by %s", ErrInvalidOptions, AlgoRSA)
+	}
+	if o.KeySize == 0 {
+		return DefaultRSAKeySize, nil
+	}
+	for _, size := range rsaKeySizes {
+		if o.KeySize == size {
+			return size, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: key_size: value must be of values: %s", ErrInvalidOptions, strings.Trim(fmt.Sprint(rsaKeySizes), "[]"))
+}
+
+// rsaScheme holds the validated signature scheme and digest of an RSA signer.
+// The zero value is the PKCS#1 v1.5 scheme with the default digest.
+type rsaScheme struct {
+	name       string
+	hash       stdcrypto.Hash
+	saltLength int
+}
+
+// rsaScheme validates the signature scheme options of an RSA key of keySize bits.
+func (o SignerOptions) rsaScheme(keySize int) (rsaScheme, error) {
+	hash := defaultHash(AlgoRSA, keySize)
+	if o.Hash != "" {
+		var err error
+		if hash, err = parseHash(o.Hash); err != nil {
+			return rsaScheme{}, err
+		}
+	}
+
+	switch o.Scheme {
+	case "", SchemePKCS1v15:
+		if o.SaltLength != nil {
+			return rsaScheme{}, fmt.Errorf("%w: salt_length: only supported by the %s scheme", ErrInvalidOptions, SchemePSS)
+		}
+		return rsaScheme{name: SchemePKCS1v15, hash: hash}, nil
+	case SchemePSS:
+		// the encoded message must fit the salt, the digest and two bytes of padding
+		maxSaltLength := (keySize+6)/8 - hash.Size() - 2
+		saltLength := hash.Size()
+		if o.SaltLength != nil {
+			saltLength = *o.SaltLength
+		}
+		// crypto/rsa reads a zero salt length as the maximum one, an empty salt cannot be used
+		if saltLength < 1 || saltLength > maxSaltLength {
+			return rsaScheme{}, fmt.Errorf("%w: salt_length: value must be between 1 and %d", ErrInvalidOptions, maxSaltLength)
+		}
+		return rsaScheme{name: SchemePSS, hash: hash, saltLength: saltLength}, nil
+	default:
+		return rsaScheme{}, fmt.Errorf("%w: scheme: value must be of values: %s, %s", ErrInvalidOptions, SchemePKCS1v15, SchemePSS)
+	}
+}
+
+// PSSSaltLength returns the salt length of the options, zero if it is not set.
+func (o SignerOptions) PSSSaltLength() int {
+	if o.SaltLength == nil {
+		return 0
+	}
+	return *o.SaltLength
+}
+
+// SaltLengthOption returns the SaltLength option of a salt length returned by
+// PSSSaltLength, nil (the default) for zero.
+func SaltLengthOption(saltLength int) *int {
+	if saltLength == 0 {
+		return nil
+	}
+	return &saltLength
+}
+
+// parseHash returns the digest with the given name.
+func parseHash(name string) (stdcrypto.Hash, error) {
+	hash, has := hashes[name]
+	if !has {
+		names := make([]string, 0, len(hashes))
+		for n := range hashes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("%w: hash: value must be of values: %s", ErrInvalidOptions, strings.Join(names, ", "))
+	}
+	return hash, nil
+}
+
+// checkNoSchemeOptions validates the options of an algorithm with a single signature scheme.
+func (o SignerOptions) checkNoSchemeOptions(algorithm string) error {
+	if o.Scheme != "" {
+		return fmt.Errorf("%w: scheme: not supported by %s", ErrInvalidOptions, algorithm)
+	}
+	if o.SaltLength != nil {
+		return fmt.Errorf("%w: salt_length: not supported by %s", ErrInvalidOptions, algorithm)
+	}
+	return nil
+}
+
+// checkNoHashOptions validates the options of an algorithm that signs the message
+// directly, without choosing a scheme or a digest.
+func (o SignerOptions) checkNoHashOptions(algorithm string) error {
+	if err := o.checkNoSchemeOptions(algorithm); err != nil {
+		return err
+	}
+	if o.Hash != "" {
+		return fmt.Errorf("%w: hash: not supported by %s", ErrInvalidOptions, algorithm)
+	}
+	return nil
+}
+
+// ecdsaHash validates the digest of an ECC key on curve. The default digest
+// matches the size of the curve (e.g. SHA-384 for P-384).
+func (o SignerOptions) ecdsaHash(curve elliptic.Curve) (stdcrypto.Hash, error) {
+	if err := o.checkNoSchemeOptions(AlgoECDSA); err != nil {
+		return 0, err
+	}
+	if o.Hash != "" {
+		return parseHash(o.Hash)
+	}
+	return defaultHash(AlgoECDSA, curve.Params().BitSize), nil
+}
+
+// rsaHash returns the default digest of RSA keys, DefaultRSAHash for every key size
+func rsaHash(int) stdcrypto.Hash {
+	return DefaultRSAHash
+}
+
+// curveHash returns the digest matching the size of a curve of bits
+func curveHash(bits int) stdcrypto.Hash {
+	switch {
+	case bits <= 256:
+		return stdcrypto.SHA256
+	case bits <= 384:
+		return stdcrypto.SHA384
+	default:
+		return stdcrypto.SHA512
+	}
+}
+
+// CheckKeyParameters validates that new keys of algorithm can be generated with the key
+// parameters of options, e.g. the parameters of an imported key that will be rotated.
+func CheckKeyParameters(algorithm string, options SignerOptions) error {
+	var err error
+	switch algorithm {
+	case AlgoRSA:
+		_, err = SignerOptions{KeySize: options.KeySize}.RSAKeySize()
+	case AlgoECDSA:
+		_, err = SignerOptions{KeySize: options.KeySize, Curve: options.Curve}.ECCCurve()
+	}
+	return err
+}
+
+// ECCCurve validates the options of an ECC key and returns the curve to use.
+// The key size follows from the curve, it is only accepted together with a matching
+// curve so that the options reported by a signer can create an equivalent one.
+func (o SignerOptions) ECCCurve() (elliptic.Curve, error) {
+	if o.KeySize != 0 && o.Curve == "" {
+		return nil, fmt.Errorf("%w: key_size: not supported by %s, choose a curve instead", ErrInvalidOptions, AlgoECDSA)
+	}
+	name := o.Curve
+	if name == "" {
+		name = DefaultCurve
+	}
+	curve, has := curves[name]
+	if !has {
+		names := make([]string, 0, len(curves))
+		for n := range curves {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w: curve: value must be of values: %s", ErrInvalidOptions, strings.Join(names, ", "))
+	}
+	if o.KeySize != 0 && o.KeySize != curve.Params().BitSize {
+		return nil, fmt.Errorf("%w: key_size: %s keys are %d bits", ErrInvalidOptions, name, curve.Params().BitSize)
+	}
+	return curve, nil
+}
+
+// checkNoKeyOptions validates the options of an algorithm with fixed key parameters
+// of keySize bits. The key size is accepted only if it matches.
+func (o SignerOptions) checkNoKeyOptions(algorithm string, keySize int) error {
+	if err := o.checkNoHashOptions(algorithm); err != nil {
+		return err
+	}
+	if o.KeySize != 0 && o.KeySize != keySize {
+		return fmt.Errorf("%w: key_size: not supported by %s", ErrInvalidOptions, algorithm)
+	}
+	if o.Curve != "" {
+		return fmt.Errorf("%w: curve: not supported by %s", ErrInvalidOptions, algorithm)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// pemPublicKeyType is the standard PEM label of a PKIX (SubjectPublicKeyInfo) public key
+const pemPublicKeyType = "PUBLIC KEY"
+
+// encodePublicKey encodes a public key as a PKIX PEM block, the format that
+// Signer.PublicKey returns and that standard tools (e.g. openssl) can read.
+func encodePublicKey(public stdcrypto.PublicKey) (string, error) {
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  pemPublicKeyType,
+		Bytes: publicKeyBytes,
+	})), nil
+}
+
+// DecodePublicKey parses a PKIX PEM public key as returned by Signer.PublicKey.
+// It returns the public key and its DER encoding.
+func DecodePublicKey(publicKeyPEM string) (stdcrypto.PublicKey, []byte, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil || block.Type != pemPublicKeyType {
+		return nil, nil, errors.New("failed to decode public key: no PUBLIC KEY PEM block found")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return public, block.Bytes, nil
+}
+
+// Verifier checks signatures with a public key only.
+type Verifier interface {
+	// Verify checks that signature has been produced over data by the private key.
+	// Returns ErrInvalidSignature if the signature does not match.
+	Verify(data []byte, signature []byte) error
+}
+
+// NewVerifier creates a Verifier for a PKIX PEM public key, as returned by Signer.PublicKey,
+// checking signatures with the scheme and digest selected by options.
+// Returns ErrUnsupportedAlgorithm for keys other than RSA, ECC and Ed25519.
+func NewVerifier(publicKeyPEM string, options SignerOptions) (Verifier, error) {
+	public, _, err := DecodePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		scheme, err := options.rsaScheme(public.N.BitLen())
+		if err != nil {
+			return nil, err
+		}
+		return &RSASigner{RSAKeyPair: &RSAKeyPair{Public: public}, scheme: scheme}, nil
+	case *ecdsa.PublicKey:
+		hash, err := options.ecdsaHash(public.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &ECCSigner{ECCKeyPair: &ECCKeyPair{Public: public}, hash: hash}, nil
+	case ed25519.PublicKey:
+		return &Ed25519KeyPair{Public: public}, nil
+	}
+	return nil, fmt.Errorf("%w: public key of type %T", ErrUnsupportedAlgorithm, public)
+}
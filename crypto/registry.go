@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrAlgorithmAlreadyRegistered is returned when registering an algorithm name twice.
+var ErrAlgorithmAlreadyRegistered = errors.New("algorithm already registered")
+
+// Algorithm describes a signing algorithm that devices can use. It bundles the
+// factories needed to create and restore a signer with the metadata of its keys.
+type Algorithm struct {
+	// Name is the unique identifier of the algorithm (e.g. "RSA")
+	Name string
+	// KeyType is the family of the key pair (e.g. "RSA", "EC", "OKP")
+	KeyType string
+	// Hash returns the default digest applied to the data before signing with a key
+	// of the given size in bits, used when the options do not choose one. It is nil
+	// when the algorithm signs the message directly (e.g. Ed25519)
+	Hash func(keyBits int) stdcrypto.Hash
+	// New generates a new key pair with the given options and returns its signer.
+	// Options that are not supported by the algorithm must be rejected with ErrInvalidOptions
+	New func(options SignerOptions) (MarshallableSigner, error)
+	// Unmarshal restores a signer from its encoded private key and the options
+	// reported by the signer when it was stored
+	Unmarshal func(privateKey []byte, options SignerOptions) (MarshallableSigner, error)
+}
+
+// Registry holds the signing algorithms available to the service, indexed by name.
+// It is safe for concurrent use.
+type Registry struct {
+	mutex      sync.RWMutex
+	algorithms map[string]Algorithm
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		algorithms: make(map[string]Algorithm),
+	}
+}
+
+// Register adds an algorithm to the registry.
+// Returns ErrAlgorithmAlreadyRegistered if the name is already taken.
+func (r *Registry) Register(algorithm Algorithm) error {
+	if algorithm.Name == "" || algorithm.New == nil || algorithm.Unmarshal == nil {
+		return fmt.Errorf("invalid algorithm %q: name, New and Unmarshal are required", algorithm.Name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, has := r.algorithms[algorithm.Name]; has {
+		return fmt.Errorf("%w: %s", ErrAlgorithmAlreadyRegistered, algorithm.Name)
+	}
+	r.algorithms[algorithm.Name] = algorithm
+	return nil
+}
+
+// Unregister removes the algorithm registered with name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.algorithms, name)
+}
+
+// Lookup returns the algorithm registered with name.
+// Returns ErrUnsupportedAlgorithm if no such algorithm exists.
+func (r *Registry) Lookup(name string) (Algorithm, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	algorithm, has := r.algorithms[name]
+	if !has {
+		return Algorithm{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, name)
+	}
+	return algorithm, nil
+}
+
+// Names returns the sorted names of all the registered algorithms.
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.algorithms))
+	for name := range r.algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultHash returns the default digest of the algorithm for a key of keyBits,
+// zero if the algorithm does not hash the message.
+func (a Algorithm) DefaultHash(keyBits int) stdcrypto.Hash {
+	if a.Hash == nil {
+		return 0
+	}
+	return a.Hash(keyBits)
+}
+
+// defaultRegistry is the process wide registry, pre-populated with the built-in algorithms.
+var defaultRegistry = NewRegistry()
+
+func init() {
+	builtins := []Algorithm{
+		{
+			Name:      AlgoRSA,
+			KeyType:   "RSA",
+			Hash:      rsaHash,
+			New:       NewRSASigner,
+			Unmarshal: UnmarshalRSASigner,
+		},
+		{
+			Name:      AlgoECDSA,
+			KeyType:   "EC",
+			Hash:      curveHash,
+			New:       NewECDSASigner,
+			Unmarshal: UnmarshalECDSASigner,
+		},
+		{
+			Name:      AlgoEd25519,
+			KeyType:   "OKP",
+			New:       NewEd25519Signer,
+			Unmarshal: UnmarshalEd25519Signer,
+		},
+	}
+	for _, algorithm := range builtins {
+		if err := defaultRegistry.Register(algorithm); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register adds an algorithm to the default registry. Packages providing their
+// own algorithms are expected to call it from an init function.
+func Register(algorithm Algorithm) error {
+	return defaultRegistry.Register(algorithm)
+}
+
+// Unregister removes an algorithm from the default registry.
+func Unregister(name string) {
+	defaultRegistry.Unregister(name)
+}
+
+// Lookup returns the algorithm registered with name in the default registry.
+func Lookup(name string) (Algorithm, error) {
+	return defaultRegistry.Lookup(name)
+}
+
+// defaultHash returns the default digest of the algorithm registered with name in
+// the default registry for a key of keyBits, zero if there is none.
+func defaultHash(name string, keyBits int) stdcrypto.Hash {
+	algorithm, err := Lookup(name)
+	if err != nil {
+		return 0
+	}
+	return algorithm.DefaultHash(keyBits)
+}
+
+// RegisteredAlgorithms returns the sorted names of the algorithms in the default registry.
+func RegisteredAlgorithms() []string {
+	return defaultRegistry.Names()
+}
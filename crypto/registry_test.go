@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"errors"
+	"testing"
+)
+
+// Test that the built-in algorithms are registered in the default registry
+func TestDefaultRegistryBuiltins(t *testing.T) {
+	for _, name := range []string{AlgoRSA, AlgoECDSA, AlgoEd25519} {
+		algorithm, err := Lookup(name)
+		if err != nil {
+			t.Fatalf("Expected %s to be registered: %v", name, err)
+		}
+		signer, err := algorithm.New(SignerOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create %s signer: %v", name, err)
+		}
+		if signer.GetAlgorithm() != name {
+			t.Errorf("Expected %s algorithm, got %s", name, signer.GetAlgorithm())
+		}
+	}
+
+	if _, err := Lookup("AES"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+// Test the default digest of the built-in algorithms, and that signers use it
+// when the options do not choose one
+func TestDefaultRegistryHash(t *testing.T) {
+	testCases := []struct {
+		algorithm string
+		options   SignerOptions
+		keyBits   int
+		expected  stdcrypto.Hash
+	}{
+		{AlgoRSA, SignerOptions{}, 2048, stdcrypto.SHA256},
+		{AlgoRSA, SignerOptions{KeySize: 3072}, 3072, stdcrypto.SHA256},
+		{AlgoECDSA, SignerOptions{Curve: "P-256"}, 256, stdcrypto.SHA256},
+		{AlgoECDSA, SignerOptions{}, 384, stdcrypto.SHA384},
+		{AlgoECDSA, SignerOptions{Curve: "P-521"}, 521, stdcrypto.SHA512},
+		{AlgoEd25519, SignerOptions{}, 256, 0},
+	}
+
+	for _, tc := range testCases {
+		algorithm, err := Lookup(tc.algorithm)
+		if err != nil {
+			t.Fatalf("Expected %s to be registered: %v", tc.algorithm, err)
+		}
+		if hash := algorithm.DefaultHash(tc.keyBits); hash != tc.expected {
+			t.Errorf("Expected %v default digest for %s of %d bits, got %v", tc.expected, tc.algorithm, tc.keyBits, hash)
+		}
+		if tc.expected == 0 {
+			continue
+		}
+		signer, err := algorithm.New(tc.options)
+		if err != nil {
+			t.Fatalf("Failed to create %s signer: %v", tc.algorithm, err)
+		}
+		if hash := signer.Options().Hash; hash != tc.expected.String() {
+			t.Errorf("Expected %s signer of %d bits to use %v, got %s", tc.algorithm, tc.keyBits, tc.expected, hash)
+		}
+	}
+}
+
+// Test registering algorithms on a custom registry
+//   - names are listed in order
+//   - a name cannot be registered twice
+//   - incomplete algorithms are rejected
+//   - unregistered names can be registered again
+func TestRegistryRegister(t *testing.T) {
+	registry := NewRegistry()
+
+	for _, name := range []string{"B", "A"} {
+		err := registry.Register(Algorithm{Name: name, New: NewEd25519Signer, Unmarshal: UnmarshalEd25519Signer})
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", name, err)
+		}
+	}
+
+	names := registry.Names()
+	if len(names) != 2 || names[0] != "A" || names[1] != "B" {
+		t.Errorf("Expected [A B], got %v", names)
+	}
+
+	err := registry.Register(Algorithm{Name: "A", New: NewEd25519Signer, Unmarshal: UnmarshalEd25519Signer})
+	if !errors.Is(err, ErrAlgorithmAlreadyRegistered) {
+		t.Errorf("Expected ErrAlgorithmAlreadyRegistered, got %v", err)
+	}
+
+	if err := registry.Register(Algorithm{Name: "C"}); err == nil {
+		t.Error("Expected error when registering an algorithm without factories")
+	}
+
+	registry.Unregister("A")
+	if _, err := registry.Lookup("A"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+	if err := registry.Register(Algorithm{Name: "A", New: NewEd25519Signer, Unmarshal: UnmarshalEd25519Signer}); err != nil {
+		t.Errorf("Failed to register A again: %v", err)
+	}
+}
@@ -15,11 +15,15 @@ type RSAKeyPair struct {
 	Private *rsa.PrivateKey
 }
 
-// Sign data with the key
+// Sign data with the key using the PKCS#1 v1.5 scheme and the default digest
 func (keys *RSAKeyPair) Sign(dataToBeSigned []byte) ([]byte, error) {
+	return keys.SignPKCS1v15(dataToBeSigned, defaultHash(AlgoRSA, keys.Private.N.BitLen()))
+}
 
-	hash := computeHash(dataToBeSigned)
-	signature, err := rsa.SignPKCS1v15(rand.Reader, keys.Private, crypto.SHA256, hash[:])
+// SignPKCS1v15 signs data with the key using the RSASSA-PKCS1-v1_5 scheme
+func (keys *RSAKeyPair) SignPKCS1v15(dataToBeSigned []byte, hash crypto.Hash) ([]byte, error) {
+	digest := computeHash(dataToBeSigned, hash)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, keys.Private, hash, digest)
 	if err != nil {
 		return []byte{}, fmt.Errorf("failed to sign data with RSA: %v", err)
 	}
@@ -27,10 +31,53 @@ func (keys *RSAKeyPair) Sign(dataToBeSigned []byte) ([]byte, error) {
 	return signature, nil
 }
 
+// SignPSS signs data with the key using the RSASSA-PSS scheme
+func (keys *RSAKeyPair) SignPSS(dataToBeSigned []byte, hash crypto.Hash, saltLength int) ([]byte, error) {
+	digest := computeHash(dataToBeSigned, hash)
+	signature, err := rsa.SignPSS(rand.Reader, keys.Private, hash, digest, &rsa.PSSOptions{
+		SaltLength: saltLength,
+		Hash:       hash,
+	})
+	if err != nil {
+		return []byte{}, fmt.Errorf("failed to sign data with RSA-PSS: %v", err)
+	}
+
+	return signature, nil
+}
+
+// VerifyPKCS1v15 checks an RSASSA-PKCS1-v1_5 signature of data against the public key.
+// Returns ErrInvalidSignature if the signature does not match.
+func (keys *RSAKeyPair) VerifyPKCS1v15(data []byte, signature []byte, hash crypto.Hash) error {
+	digest := computeHash(data, hash)
+	if err := rsa.VerifyPKCS1v15(keys.Public, hash, digest, signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// VerifyPSS checks an RSASSA-PSS signature of data against the public key.
+// Returns ErrInvalidSignature if the signature does not match.
+func (keys *RSAKeyPair) VerifyPSS(data []byte, signature []byte, hash crypto.Hash, saltLength int) error {
+	digest := computeHash(data, hash)
+	err := rsa.VerifyPSS(keys.Public, hash, digest, signature, &rsa.PSSOptions{
+		SaltLength: saltLength,
+		Hash:       hash,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
 func (s *RSAKeyPair) GetAlgorithm() string {
 	return AlgoRSA
 }
 
+// Options returns the parameters of the key pair
+func (s *RSAKeyPair) Options() SignerOptions {
+	return SignerOptions{KeySize: s.Private.N.BitLen()}
+}
+
 // RSAMarshaler can encode and decode an RSA key pair.
 type RSAMarshaler struct{}
 
@@ -58,12 +105,26 @@ func (m *RSAMarshaler) Marshal(keyPair RSAKeyPair) ([]byte, []byte, error) {
 	return encodePublic, encodedPrivate, nil
 }
 
-// Unmarshal takes an encoded RSA private key and transforms it into a rsa.PrivateKey.
+// Unmarshal takes a PEM encoded RSA private key, PKCS#1 or PKCS#8, and transforms it into a rsa.PrivateKey.
+// Returns ErrInvalidKey if the key cannot be decoded or is not a valid RSA key.
 func (m *RSAMarshaler) Unmarshal(privateKeyBytes []byte) (*RSAKeyPair, error) {
 	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
 	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("%w: not a PKCS#1 or PKCS#8 RSA key: %v", ErrInvalidKey, err)
+		}
+		var ok bool
+		if privateKey, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%w: expected an RSA key, got %T", ErrInvalidKey, key)
+		}
+	}
+	if err := privateKey.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
 	}
 
 	return &RSAKeyPair{
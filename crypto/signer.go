@@ -1,10 +1,21 @@
 package crypto
 
+import (
+	stdcrypto "crypto"
+	"crypto/ed25519"
+)
+
 // Signer defines a contract for different types of signing implementations.
 type Signer interface {
 	Sign(dataToBeSigned []byte) ([]byte, error)
+	// Verify checks that signature has been produced by the signer key over data.
+	// Returns ErrInvalidSignature if the signature does not match.
+	Verify(data []byte, signature []byte) error
 	GetAlgorithm() string
+	// PublicKey returns the public key as a PKIX PEM block
 	PublicKey() string
+	// Options returns the parameters the signer has been created with
+	Options() SignerOptions
 }
 
 // KeyMarhsaller implements a key pair that can be exported
@@ -25,59 +36,185 @@ type MarshallableSigner interface {
 type ECCSigner struct {
 	ECCMarshaler
 	*ECCKeyPair
+	hash stdcrypto.Hash
 }
 
-func NewECDSASigner() (MarshallableSigner, error) {
-	g := &ECCGenerator{}
+func NewECDSASigner(options SignerOptions) (MarshallableSigner, error) {
+	curve, err := options.ECCCurve()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := options.ecdsaHash(curve)
+	if err != nil {
+		return nil, err
+	}
+	g := &ECCGenerator{Curve: curve}
 	keys, err := g.Generate()
-	return &ECCSigner{ECCKeyPair: keys}, err
+	return &ECCSigner{ECCKeyPair: keys, hash: hash}, err
+}
+
+// Sign data with the digest of the signer
+func (s *ECCSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	return s.ECCKeyPair.SignWithHash(dataToBeSigned, s.digest())
+}
+
+// Verify an ECDSA signature with the digest of the signer
+func (s *ECCSigner) Verify(data []byte, signature []byte) error {
+	return s.ECCKeyPair.VerifyWithHash(data, signature, s.digest())
+}
+
+// Options returns the curve and the digest of the signer
+func (s *ECCSigner) Options() SignerOptions {
+	options := s.ECCKeyPair.Options()
+	options.Hash = s.digest().String()
+	return options
+}
+
+// digest returns the hash of the signer, the default digest of the curve if none has been set
+func (s *ECCSigner) digest() stdcrypto.Hash {
+	if s.hash == 0 {
+		return defaultHash(AlgoECDSA, s.ECCKeyPair.Public.Curve.Params().BitSize)
+	}
+	return s.hash
 }
 
 type RSASigner struct {
 	RSAMarshaler
 	*RSAKeyPair
+	scheme rsaScheme
 }
 
-func NewRSASigner() (MarshallableSigner, error) {
-	g := &RSAGenerator{}
+func NewRSASigner(options SignerOptions) (MarshallableSigner, error) {
+	bits, err := options.RSAKeySize()
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := options.rsaScheme(bits)
+	if err != nil {
+		return nil, err
+	}
+	g := &RSAGenerator{Bits: bits}
 	keys, err := g.Generate()
-	return &RSASigner{RSAKeyPair: keys}, err
+	return &RSASigner{RSAKeyPair: keys, scheme: scheme}, err
+}
+
+// Sign data with the signature scheme and the digest of the signer
+func (s *RSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	if s.scheme.name == SchemePSS {
+		return s.RSAKeyPair.SignPSS(dataToBeSigned, s.digest(), s.scheme.saltLength)
+	}
+	return s.RSAKeyPair.SignPKCS1v15(dataToBeSigned, s.digest())
+}
+
+// Verify an RSA signature with the signature scheme and the digest of the signer
+func (s *RSASigner) Verify(data []byte, signature []byte) error {
+	if s.scheme.name == SchemePSS {
+		return s.RSAKeyPair.VerifyPSS(data, signature, s.digest(), s.scheme.saltLength)
+	}
+	return s.RSAKeyPair.VerifyPKCS1v15(data, signature, s.digest())
+}
+
+// Options returns the key size, the signature scheme and the digest of the signer
+func (s *RSASigner) Options() SignerOptions {
+	options := s.RSAKeyPair.Options()
+	options.Scheme = SchemePKCS1v15
+	options.Hash = s.digest().String()
+	if s.scheme.name == SchemePSS {
+		options.Scheme = SchemePSS
+		options.SaltLength = SaltLengthOption(s.scheme.saltLength)
+	}
+	return options
+}
+
+// digest returns the hash of the signer, the default digest of RSA if none has been set
+func (s *RSASigner) digest() stdcrypto.Hash {
+	if s.scheme.hash == 0 {
+		return defaultHash(AlgoRSA, s.RSAKeyPair.Public.N.BitLen())
+	}
+	return s.scheme.hash
 }
 
+// PublicKey returns the public key of the signer as a PKIX PEM block
 func (s *ECCSigner) PublicKey() string {
-	public, _, err := s.Marshal()
+	public, err := encodePublicKey(s.ECCKeyPair.Public)
 	if err != nil {
 		// TODO: handle this
 		panic(err)
 	}
-	return string(public)
+	return public
 }
 
 func (s *ECCSigner) Marshal() ([]byte, []byte, error) {
 	return s.ECCMarshaler.Marshal(*s.ECCKeyPair)
 }
 
-func UnmarshalECDSASigner(privateKey []byte) (MarshallableSigner, error) {
+func UnmarshalECDSASigner(privateKey []byte, options SignerOptions) (MarshallableSigner, error) {
 	g := NewECCMarshaler()
 	keys, err := g.Unmarshal(privateKey)
-	return &ECCSigner{ECCKeyPair: keys}, err
+	if err != nil {
+		return nil, err
+	}
+	hash, err := options.ecdsaHash(keys.Private.Curve)
+	return &ECCSigner{ECCKeyPair: keys, hash: hash}, err
 }
 
-func UnmarshalRSASigner(privateKey []byte) (MarshallableSigner, error) {
+func UnmarshalRSASigner(privateKey []byte, options SignerOptions) (MarshallableSigner, error) {
 	g := NewRSAMarshaler()
 	keys, err := g.Unmarshal(privateKey)
-	return &RSASigner{RSAKeyPair: keys}, err
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := options.rsaScheme(keys.Private.N.BitLen())
+	return &RSASigner{RSAKeyPair: keys, scheme: scheme}, err
 }
 
+// PublicKey returns the public key of the signer as a PKIX PEM block
 func (s *RSASigner) PublicKey() string {
-	public, _, err := s.Marshal()
+	public, err := encodePublicKey(s.RSAKeyPair.Public)
 	if err != nil {
 		// TODO: handle this
 		panic(err)
 	}
-	return string(public)
+	return public
 }
 
 func (s *RSASigner) Marshal() ([]byte, []byte, error) {
 	return s.RSAMarshaler.Marshal(*s.RSAKeyPair)
 }
+
+type Ed25519Signer struct {
+	Ed25519Marshaler
+	*Ed25519KeyPair
+}
+
+func NewEd25519Signer(options SignerOptions) (MarshallableSigner, error) {
+	if err := options.checkNoKeyOptions(AlgoEd25519, 8*ed25519.PublicKeySize); err != nil {
+		return nil, err
+	}
+	g := &Ed25519Generator{}
+	keys, err := g.Generate()
+	return &Ed25519Signer{Ed25519KeyPair: keys}, err
+}
+
+func UnmarshalEd25519Signer(privateKey []byte, options SignerOptions) (MarshallableSigner, error) {
+	if err := options.checkNoHashOptions(AlgoEd25519); err != nil {
+		return nil, err
+	}
+	g := NewEd25519Marshaler()
+	keys, err := g.Unmarshal(privateKey)
+	return &Ed25519Signer{Ed25519KeyPair: keys}, err
+}
+
+// PublicKey returns the public key of the signer as a PKIX PEM block
+func (s *Ed25519Signer) PublicKey() string {
+	public, err := encodePublicKey(s.Ed25519KeyPair.Public)
+	if err != nil {
+		// TODO: handle this
+		panic(err)
+	}
+	return public
+}
+
+func (s *Ed25519Signer) Marshal() ([]byte, []byte, error) {
+	return s.Ed25519Marshaler.Marshal(*s.Ed25519KeyPair)
+}
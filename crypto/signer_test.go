@@ -1,11 +1,19 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"reflect"
 	"testing"
 )
 
-// TODO: Add verify for signature verification
-
 // Test creation of RSA and ECDSA signer
 func TestSignerCreation(t *testing.T) {
 	// Test RSA signer creation
@@ -27,6 +35,16 @@ func TestSignerCreation(t *testing.T) {
 	if eccSigner.GetAlgorithm() != AlgoECDSA {
 		t.Errorf("Expected %s algorithm, got %s", AlgoECDSA, rsaSigner.GetAlgorithm())
 	}
+
+	// Test Ed25519 signer creation
+	edKeyPair, err := (&Ed25519Generator{}).Generate()
+	if err != nil {
+		t.Errorf("Failed to generate Ed25519 key pair: %v", err)
+	}
+	edSigner := Ed25519Signer{Ed25519KeyPair: edKeyPair}
+	if edSigner.GetAlgorithm() != AlgoEd25519 {
+		t.Errorf("Expected %s algorithm, got %s", AlgoEd25519, edSigner.GetAlgorithm())
+	}
 }
 
 // Test Marshalling of a (RSA) Signer
@@ -92,3 +110,368 @@ func TestSignerMarshalUnmarshalECDSA(t *testing.T) {
 		t.Error("ECC keys do not match after marshal/unmarshal")
 	}
 }
+
+// Test Marshalling of a (Ed25519) Signer
+//   - create a signer
+//   - marshal and unmarshal the signer
+//   - verify the private key is the same
+func TestSignerMarshalUnmarshalEd25519(t *testing.T) {
+
+	// Test Ed25519 signer
+	edKeyPair, err := (&Ed25519Generator{}).Generate()
+	if err != nil {
+		t.Errorf("Failed to generate Ed25519 key pair: %v", err)
+	}
+	edSigner := &Ed25519Signer{
+		Ed25519KeyPair: edKeyPair,
+	}
+
+	// Marshal and unmarshal
+	_, edPrivKey, err := edSigner.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal Ed25519 keys: %v", err)
+	}
+
+	edMarshaler := NewEd25519Marshaler()
+	newEdKeyPair, err := edMarshaler.Unmarshal(edPrivKey)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal Ed25519 keys: %v", err)
+	}
+
+	if !edKeyPair.Private.Equal(newEdKeyPair.Private) {
+		t.Error("Ed25519 keys do not match after marshal/unmarshal")
+	}
+}
+
+// Test the key options of the built-in algorithms
+//   - supported values are applied to the generated key
+//   - unsupported values are rejected with ErrInvalidOptions
+func TestSignerOptions(t *testing.T) {
+	testCases := []struct {
+		name      string
+		newSigner func(SignerOptions) (MarshallableSigner, error)
+		options   SignerOptions
+		expected  SignerOptions
+		valid     bool
+	}{
+		{"RSA default", NewRSASigner, SignerOptions{}, SignerOptions{KeySize: DefaultRSAKeySize, Scheme: SchemePKCS1v15, Hash: "SHA-256"}, true},
+		{"RSA 3072", NewRSASigner, SignerOptions{KeySize: 3072}, SignerOptions{KeySize: 3072, Scheme: SchemePKCS1v15, Hash: "SHA-256"}, true},
+		{"RSA SHA-512", NewRSASigner, SignerOptions{Hash: "SHA-512"}, SignerOptions{KeySize: DefaultRSAKeySize, Scheme: SchemePKCS1v15, Hash: "SHA-512"}, true},
+		{"RSA PSS default", NewRSASigner, SignerOptions{Scheme: SchemePSS}, SignerOptions{KeySize: DefaultRSAKeySize, Scheme: SchemePSS, SaltLength: SaltLengthOption(32), Hash: "SHA-256"}, true},
+		{"RSA PSS SHA-512", NewRSASigner, SignerOptions{Scheme: SchemePSS, SaltLength: SaltLengthOption(20), Hash: "SHA-512"}, SignerOptions{KeySize: DefaultRSAKeySize, Scheme: SchemePSS, SaltLength: SaltLengthOption(20), Hash: "SHA-512"}, true},
+		{"RSA PSS empty salt", NewRSASigner, SignerOptions{Scheme: SchemePSS, SaltLength: new(int)}, SignerOptions{}, false},
+		{"RSA PSS salt too long", NewRSASigner, SignerOptions{Scheme: SchemePSS, SaltLength: SaltLengthOption(256)}, SignerOptions{}, false},
+		{"RSA PSS MD5", NewRSASigner, SignerOptions{Scheme: SchemePSS, Hash: "MD5"}, SignerOptions{}, false},
+		{"RSA unknown scheme", NewRSASigner, SignerOptions{Scheme: "OAEP"}, SignerOptions{}, false},
+		{"RSA PKCS1v15 with salt", NewRSASigner, SignerOptions{SaltLength: SaltLengthOption(32)}, SignerOptions{}, false},
+		{"RSA PKCS1v15 with empty salt", NewRSASigner, SignerOptions{SaltLength: new(int)}, SignerOptions{}, false},
+		{"RSA SHA-1", NewRSASigner, SignerOptions{Hash: "SHA-1"}, SignerOptions{}, false},
+		{"RSA 1024", NewRSASigner, SignerOptions{KeySize: 1024}, SignerOptions{}, false},
+		{"RSA with curve", NewRSASigner, SignerOptions{Curve: "P-256"}, SignerOptions{}, false},
+		{"ECC default", NewECDSASigner, SignerOptions{}, SignerOptions{KeySize: 384, Curve: DefaultCurve, Hash: "SHA-384"}, true},
+		{"ECC P-256", NewECDSASigner, SignerOptions{Curve: "P-256"}, SignerOptions{KeySize: 256, Curve: "P-256", Hash: "SHA-256"}, true},
+		{"ECC P-521", NewECDSASigner, SignerOptions{Curve: "P-521"}, SignerOptions{KeySize: 521, Curve: "P-521", Hash: "SHA-512"}, true},
+		{"ECC P-256 SHA-512", NewECDSASigner, SignerOptions{Curve: "P-256", Hash: "SHA-512"}, SignerOptions{KeySize: 256, Curve: "P-256", Hash: "SHA-512"}, true},
+		{"ECC P-224", NewECDSASigner, SignerOptions{Curve: "P-224"}, SignerOptions{}, false},
+		{"ECC with key size", NewECDSASigner, SignerOptions{KeySize: 256}, SignerOptions{}, false},
+		{"ECC with key size of the curve", NewECDSASigner, SignerOptions{KeySize: 256, Curve: "P-256"}, SignerOptions{KeySize: 256, Curve: "P-256", Hash: "SHA-256"}, true},
+		{"ECC with key size of another curve", NewECDSASigner, SignerOptions{KeySize: 384, Curve: "P-256"}, SignerOptions{}, false},
+		{"ECC with scheme", NewECDSASigner, SignerOptions{Scheme: SchemePSS}, SignerOptions{}, false},
+		{"Ed25519 default", NewEd25519Signer, SignerOptions{}, SignerOptions{KeySize: 256}, true},
+		{"Ed25519 with key size", NewEd25519Signer, SignerOptions{KeySize: 256}, SignerOptions{KeySize: 256}, true},
+		{"Ed25519 with curve", NewEd25519Signer, SignerOptions{Curve: "P-256"}, SignerOptions{}, false},
+		{"Ed25519 with hash", NewEd25519Signer, SignerOptions{Hash: "SHA-256"}, SignerOptions{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := tc.newSigner(tc.options)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Errorf("Expected ErrInvalidOptions, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to create signer: %v", err)
+			}
+			if !reflect.DeepEqual(signer.Options(), tc.expected) {
+				t.Errorf("Expected options %+v, got %+v", tc.expected, signer.Options())
+			}
+		})
+	}
+}
+
+// Test that an RSA-PSS signer keeps its scheme through marshal/unmarshal and
+// produces signatures that verify with the PSS padding
+func TestSignerRSAPSS(t *testing.T) {
+	options := SignerOptions{Scheme: SchemePSS, SaltLength: SaltLengthOption(48), Hash: "SHA-384"}
+	signer, err := NewRSASigner(options)
+	if err != nil {
+		t.Fatalf("Failed to create RSA-PSS signer: %v", err)
+	}
+
+	_, privateKey, err := signer.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal RSA keys: %v", err)
+	}
+	restored, err := UnmarshalRSASigner(privateKey, signer.Options())
+	if err != nil {
+		t.Fatalf("Failed to unmarshal RSA-PSS signer: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Options(), signer.Options()) {
+		t.Errorf("Expected options %+v, got %+v", signer.Options(), restored.Options())
+	}
+
+	data := []byte("data")
+	signature, err := restored.Sign(data)
+	if err != nil {
+		t.Fatalf("Failed to sign data: %v", err)
+	}
+	keys := restored.(*RSASigner).RSAKeyPair
+	digest := computeHash(data, stdcrypto.SHA384)
+	err = rsa.VerifyPSS(keys.Public, stdcrypto.SHA384, digest, signature, &rsa.PSSOptions{SaltLength: 48})
+	if err != nil {
+		t.Errorf("Failed to verify RSA-PSS signature: %v", err)
+	}
+}
+
+// Test that an ECC signer signs with its digest, also after marshal/unmarshal
+func TestSignerECDSAHash(t *testing.T) {
+	signer, err := NewECDSASigner(SignerOptions{Curve: "P-384"})
+	if err != nil {
+		t.Fatalf("Failed to create ECC signer: %v", err)
+	}
+
+	_, privateKey, err := signer.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal ECC keys: %v", err)
+	}
+	restored, err := UnmarshalECDSASigner(privateKey, signer.Options())
+	if err != nil {
+		t.Fatalf("Failed to unmarshal ECC signer: %v", err)
+	}
+	if restored.Options().Hash != "SHA-384" {
+		t.Errorf("Expected SHA-384 digest, got %s", restored.Options().Hash)
+	}
+
+	data := []byte("data")
+	signature, err := restored.Sign(data)
+	if err != nil {
+		t.Fatalf("Failed to sign data: %v", err)
+	}
+	keys := restored.(*ECCSigner).ECCKeyPair
+	if !ecdsa.VerifyASN1(keys.Public, computeHash(data, stdcrypto.SHA384), signature) {
+		t.Error("Failed to verify ECC signature with SHA-384 digest")
+	}
+}
+
+// Test signature verification of all the built-in signers
+//   - a signature verifies against the data it was produced for, also with the public key only
+//   - tampered data or signatures are rejected with ErrInvalidSignature
+func TestSignerVerify(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options SignerOptions
+		algo    string
+	}{
+		{"RSA", SignerOptions{}, AlgoRSA},
+		{"RSA PSS", SignerOptions{Scheme: SchemePSS, Hash: "SHA-384"}, AlgoRSA},
+		{"ECC", SignerOptions{Curve: "P-256"}, AlgoECDSA},
+		{"Ed25519", SignerOptions{}, AlgoEd25519},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			algorithm, err := Lookup(tc.algo)
+			if err != nil {
+				t.Fatalf("Failed to lookup algorithm: %v", err)
+			}
+			signer, err := algorithm.New(tc.options)
+			if err != nil {
+				t.Fatalf("Failed to create signer: %v", err)
+			}
+
+			data := []byte("data")
+			signature, err := signer.Sign(data)
+			if err != nil {
+				t.Fatalf("Failed to sign data: %v", err)
+			}
+			if err := signer.Verify(data, signature); err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+
+			// the public key and the options are enough to verify
+			verifier, err := NewVerifier(signer.PublicKey(), signer.Options())
+			if err != nil {
+				t.Fatalf("Failed to create verifier: %v", err)
+			}
+			if err := verifier.Verify(data, signature); err != nil {
+				t.Errorf("Expected valid signature with the public key verifier, got %v", err)
+			}
+
+			if err := signer.Verify([]byte("other data"), signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature for tampered data, got %v", err)
+			}
+
+			signature[0] ^= 0xff
+			if err := signer.Verify(data, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature for tampered signature, got %v", err)
+			}
+		})
+	}
+}
+
+// Test the signer of keys held outside of the process, using in memory keys as the external store
+//   - signatures are produced with the selected scheme and digest
+//   - only the public key is marshalled
+//   - unsupported key types are rejected
+func TestExternalSigner(t *testing.T) {
+	rsaKeyPair, err := (&RSAGenerator{}).Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key pair: %v", err)
+	}
+	eccKeyPair, err := (&ECCGenerator{}).Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate ECC key pair: %v", err)
+	}
+
+	data := []byte("data")
+	rsaSigner, err := NewExternalSigner(rsaKeyPair.Private, SignerOptions{Scheme: SchemePSS, Hash: "SHA-512"})
+	if err != nil {
+		t.Fatalf("Failed to create RSA signer: %v", err)
+	}
+	signature, err := rsaSigner.Sign(data)
+	if err != nil {
+		t.Fatalf("Failed to sign data: %v", err)
+	}
+	if err := rsaKeyPair.VerifyPSS(data, signature, stdcrypto.SHA512, stdcrypto.SHA512.Size()); err != nil {
+		t.Errorf("Failed to verify RSA-PSS signature: %v", err)
+	}
+	if err := rsaSigner.Verify(data, signature); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+	expected := SignerOptions{KeySize: 2048, Scheme: SchemePSS, SaltLength: SaltLengthOption(64), Hash: "SHA-512"}
+	if options := rsaSigner.Options(); !reflect.DeepEqual(options, expected) {
+		t.Errorf("Expected options %+v, got %+v", expected, options)
+	}
+
+	eccSigner, err := NewExternalSigner(eccKeyPair.Private, SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create ECC signer: %v", err)
+	}
+	if eccSigner.GetAlgorithm() != AlgoECDSA {
+		t.Errorf("Expected %s algorithm, got %s", AlgoECDSA, eccSigner.GetAlgorithm())
+	}
+	signature, err = eccSigner.Sign(data)
+	if err != nil {
+		t.Fatalf("Failed to sign data: %v", err)
+	}
+	if err := eccKeyPair.VerifyWithHash(data, signature, stdcrypto.SHA384); err != nil {
+		t.Errorf("Failed to verify ECC signature: %v", err)
+	}
+	if err := eccSigner.Verify([]byte("other data"), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for tampered data, got %v", err)
+	}
+
+	public, private, err := eccSigner.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal signer: %v", err)
+	}
+	if private != nil {
+		t.Error("Expected no private key to be marshalled")
+	}
+	if string(public) != eccSigner.PublicKey() {
+		t.Error("Expected the marshalled public key to match")
+	}
+
+	edKeyPair, err := (&Ed25519Generator{}).Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key pair: %v", err)
+	}
+	if _, err := NewExternalSigner(edKeyPair.Private, SignerOptions{}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+// Test decoding of externally generated private keys
+//   - PKCS#1, SEC 1 and PKCS#8 PEM keys are accepted
+//   - malformed PEM, keys of another algorithm and unsupported curves are
+//     rejected with ErrInvalidKey instead of panicking
+func TestUnmarshalImportedKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECC key: %v", err)
+	}
+	weakKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	encode := func(blockType string, der []byte, err error) []byte {
+		if err != nil {
+			t.Fatalf("Failed to encode key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	}
+	pkcs8 := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		return encode("PRIVATE KEY", der, err)
+	}
+	sec1 := func(key *ecdsa.PrivateKey) []byte {
+		der, err := x509.MarshalECPrivateKey(key)
+		return encode("EC PRIVATE KEY", der, err)
+	}
+
+	testCases := []struct {
+		name      string
+		unmarshal func([]byte, SignerOptions) (MarshallableSigner, error)
+		key       []byte
+		valid     bool
+	}{
+		{"RSA PKCS#1", UnmarshalRSASigner, encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil), true},
+		{"RSA PKCS#8", UnmarshalRSASigner, pkcs8(rsaKey), true},
+		{"RSA with ECC key", UnmarshalRSASigner, pkcs8(eccKey), false},
+		{"RSA not PEM", UnmarshalRSASigner, []byte("not a key"), false},
+		{"ECC SEC 1", UnmarshalECDSASigner, sec1(eccKey), true},
+		{"ECC PKCS#8", UnmarshalECDSASigner, pkcs8(eccKey), true},
+		{"ECC with RSA key", UnmarshalECDSASigner, pkcs8(rsaKey), false},
+		{"ECC P-224", UnmarshalECDSASigner, sec1(weakKey), false},
+		{"ECC not PEM", UnmarshalECDSASigner, []byte{}, false},
+		{"Ed25519 PKCS#8", UnmarshalEd25519Signer, pkcs8(edKey), true},
+		{"Ed25519 with ECC key", UnmarshalEd25519Signer, pkcs8(eccKey), false},
+		{"Ed25519 corrupted", UnmarshalEd25519Signer, encode("PRIVATE KEY", []byte{0x30, 0x00}, nil), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := tc.unmarshal(tc.key, SignerOptions{})
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Errorf("Expected ErrInvalidKey, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to unmarshal key: %v", err)
+			}
+			data := []byte("data")
+			signature, err := signer.Sign(data)
+			if err != nil {
+				t.Fatalf("Failed to sign data: %v", err)
+			}
+			if err := signer.Verify(data, signature); err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// chainVerifier walks the signatures of a device in counter order and finds the first
+// broken link of the chain: a gap or a duplicate in the counters, secured data not
+// embedding the previous signature or a signature not matching the device key.
+type chainVerifier struct {
+	device *signatureDevice
+	// next is the counter of the next signature of the chain
+	next uint64
+	// previous is the signature the next secured data must embed
+	previous string
+	// verifiers caches the verifiers of the key versions of the device
+	verifiers map[int]crypto.Verifier
+}
+
+func newChainVerifier(device *signatureDevice) *chainVerifier {
+	return &chainVerifier{
+		device:    device,
+		previous:  base64.StdEncoding.EncodeToString([]byte(device.ID)),
+		verifiers: make(map[int]crypto.Verifier),
+	}
+}
+
+// check verifies the next signature of the chain, signatures must be checked in counter order.
+// Returns the broken link or nil if the signature extends the chain.
+func (c *chainVerifier) check(signature common.SignatureDTO) (*common.ChainBreak, error) {
+	broken := func(reason string, args ...any) *common.ChainBreak {
+		return &common.ChainBreak{Counter: signature.Counter, SignatureID: signature.ID, Reason: fmt.Sprintf(reason, args...)}
+	}
+	if signature.Counter < c.next {
+		return broken("duplicate signature with counter %d", signature.Counter), nil
+	}
+	if signature.Counter > c.next {
+		return c.missing(signature.Counter), nil
+	}
+
+	secured := securedData{
+		counter:           signature.Counter,
+		dataToBeSigned:    signature.DataToBeSigned,
+		previousSignature: signature.PreviousSignature,
+	}
+	if secured.String() != signature.SignedData {
+		return broken("signed data does not match the counter, data to be signed and previous signature"), nil
+	}
+	if secured.previousSignature != c.previous {
+		return broken("signed data does not embed the previous signature of the chain"), nil
+	}
+
+	// the key is found by the counter, the key version stored with the signature is not trusted:
+	// a retired key must not sign past its rotation. Zero is the first version of signatures
+	// stored before keys could be rotated
+	version := c.device.keyVersionAt(signature.Counter)
+	if claimed := signature.KeyVersion; claimed != version && (claimed != 0 || version != 1) {
+		return broken("signature claims the key version %d, counter %d belongs to the key version %d of the device", claimed, signature.Counter, version), nil
+	}
+	verifier, err := c.verifier(version)
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return broken("signature cannot be decoded from base64"), nil
+	}
+	err = verifier.Verify([]byte(signature.SignedData), signatureBytes)
+	if errors.Is(err, crypto.ErrInvalidSignature) {
+		return broken("signature does not match the signed data with the key version %d of the device", version), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.next++
+	c.previous = signature.Signature
+	return nil, nil
+}
+
+// missing returns the broken link of the signatures missing before counter
+func (c *chainVerifier) missing(counter uint64) *common.ChainBreak {
+	if counter-c.next == 1 {
+		return &common.ChainBreak{Counter: c.next, Reason: fmt.Sprintf("missing signature with counter %d", c.next)}
+	}
+	return &common.ChainBreak{Counter: c.next, Reason: fmt.Sprintf("missing signatures with counter %d to %d", c.next, counter-1)}
+}
+
+// verifier returns the cached verifier of a key version
+func (c *chainVerifier) verifier(version int) (crypto.Verifier, error) {
+	if verifier, has := c.verifiers[version]; has {
+		return verifier, nil
+	}
+	verifier, err := c.device.verifier(version)
+	if err != nil {
+		return nil, err
+	}
+	c.verifiers[version] = verifier
+	return verifier, nil
+}
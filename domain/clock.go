@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// Clock provides the current time to the services, it can be replaced
+// to make timestamps deterministic (e.g. in tests)
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock of the system time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"encoding/base64"
 	"fmt"
+	"time"
 
 	"github.com/AloveIs/signing-device-service-go/common"
 	"github.com/AloveIs/signing-device-service-go/crypto"
@@ -16,56 +17,244 @@ type signatureDevice struct {
 	ID string
 	// signer is the object that can sign a message
 	signer crypto.MarshallableSigner
+	// keyBackend is the key store holding the private key, crypto.KeyBackendSoftware
+	// if the key is kept with the device
+	keyBackend string
+	// keyLabel references the private key in the key store of an external backend
+	keyLabel string
+	// keyVersion is the version of the current key, starting from 1
+	keyVersion int
+	// keyHistory holds the retired keys, oldest first
+	keyHistory []common.RetiredKey
+	// status is the lifecycle state, only active devices can sign
+	status string
+	// finalCounter and decommissionedAt are recorded when the device is decommissioned
+	finalCounter     uint64
+	decommissionedAt time.Time
 	// label is an optional alternative name for the device
 	Label *string
+	// metadata are user defined key/value tags, nil if there are none
+	metadata map[string]string
 	// counter of the number of signature performed
 	signatureCounter uint64
 	// last signature performed
 	LastSignature string
+	// version is incremented by the repository at every change, new devices are saved with version 1
+	version uint64
 }
 
-// Create a new signature device from and algorithm and an optional label
-func newDevice(algorithm string, label *string) (signatureDevice, error) {
-	signer, err := newSigner(algorithm)
+// Create a new signature device from and algorithm, its key options and an optional label
+func newDevice(algorithm string, options crypto.SignerOptions, label *string) (signatureDevice, error) {
+	return newDeviceOnBackend(crypto.KeyBackendSoftware, "", algorithm, options, label)
+}
+
+// Create a new signature device whose key is generated by the key store of backend.
+// An empty id generates a new one. External keys are stored under a new unique label,
+// the ID of the device if it is generated, so that a client supplied ID never matches
+// the key of another device.
+func newDeviceOnBackend(backend string, id string, algorithm string, options crypto.SignerOptions, label *string) (signatureDevice, error) {
+	keyLabel := generateDeviceId()
+	if id == "" {
+		id = keyLabel
+	}
+	signer, err := newBackendSigner(backend, algorithm, options, keyLabel)
 
 	if err != nil {
 		return signatureDevice{}, err
 	}
 	return signatureDevice{
-		ID:               generateDeviceId(),
+		ID:               id,
 		Label:            copyString(label),
 		signer:           signer,
+		keyBackend:       backend,
+		keyLabel:         keyLabel,
+		keyVersion:       1,
+		status:           StatusActive,
 		signatureCounter: 0,
+		version:          1,
+	}, nil
+}
+
+// Create a new signature device from an externally generated private key of algorithm, PEM encoded.
+// The key is kept with the device, as keys generated by the software backend. An empty id
+// generates a new one.
+func importDevice(id string, algorithm string, options crypto.SignerOptions, privateKey []byte, label *string) (signatureDevice, error) {
+	signer, err := importSigner(algorithm, options, privateKey)
+
+	if err != nil {
+		return signatureDevice{}, err
+	}
+	if id == "" {
+		id = generateDeviceId()
+	}
+	return signatureDevice{
+		ID:               id,
+		Label:            copyString(label),
+		signer:           signer,
+		keyBackend:       crypto.KeyBackendSoftware,
+		keyVersion:       1,
+		status:           StatusActive,
+		signatureCounter: 0,
+		version:          1,
 	}, nil
 }
 
 // Sign a message and return its signature
 // returns the signature, the data signed and an error
-func (d *signatureDevice) sign(dataToSign []byte) (string, string, error) {
+// Returns ErrDeviceNotActive if the device is suspended or decommissioned.
+func (d *signatureDevice) sign(dataToSign []byte) (string, securedData, error) {
+	if err := d.checkActive(); err != nil {
+		return "", securedData{}, err
+	}
 
-	securedData := d.composeDataToBeSigned(dataToSign)
+	secured := d.composeDataToBeSigned(dataToSign)
 
-	signature, err := d.signer.Sign([]byte(securedData))
+	signature, err := d.signer.Sign([]byte(secured.String()))
 
 	if err != nil {
-		return "", "", err
+		return "", securedData{}, err
 	}
 
 	d.signatureCounter++
 	d.LastSignature = base64.StdEncoding.EncodeToString([]byte(signature))
 
-	return d.LastSignature, securedData, nil
+	return d.LastSignature, secured, nil
+}
+
+// rotateKey replaces the key of the device with signer, stored under label in an external
+// key store. The current key is retired to the key history, the signature counter and
+// the chain of signatures continue with the new key.
+func (d *signatureDevice) rotateKey(signer crypto.MarshallableSigner, label string) {
+	options := d.signer.Options()
+	d.keyHistory = append(d.keyHistory, common.RetiredKey{
+		Version:     d.keyVersion,
+		KeySize:     options.KeySize,
+		Curve:       options.Curve,
+		Scheme:      options.Scheme,
+		SaltLength:  options.PSSSaltLength(),
+		Hash:        options.Hash,
+		PublicKey:   d.signer.PublicKey(),
+		CounterFrom: d.keyCounterFrom(),
+		CounterTo:   d.signatureCounter,
+	})
+	d.signer = signer
+	d.keyLabel = label
+	d.keyVersion++
+}
+
+// keyCounterFrom returns the signature counter of the first signature of the current key
+func (d *signatureDevice) keyCounterFrom() uint64 {
+	if len(d.keyHistory) == 0 {
+		return 0
+	}
+	return d.keyHistory[len(d.keyHistory)-1].CounterTo
+}
+
+// keyVersionAt returns the version of the key that signed the signature with the given counter
+func (d *signatureDevice) keyVersionAt(counter uint64) int {
+	for _, key := range d.keyHistory {
+		if counter >= key.CounterFrom && counter < key.CounterTo {
+			return key.Version
+		}
+	}
+	return d.keyVersion
+}
+
+// verifier returns the verifier of the key with the given version, current or retired.
+// Returns a ValidationError if the device never had such a key.
+func (d *signatureDevice) verifier(version int) (crypto.Verifier, error) {
+	if version == d.keyVersion {
+		return d.signer, nil
+	}
+	for _, key := range d.keyHistory {
+		if key.Version == version {
+			return crypto.NewVerifier(key.PublicKey, retiredKeyOptions(key))
+		}
+	}
+	return nil, NewValidationError([]string{fmt.Sprintf("key_version: device %s has no key version %d", d.ID, version)})
+}
+
+// retiredKeyOptions returns the options a retired key signed with
+func retiredKeyOptions(key common.RetiredKey) crypto.SignerOptions {
+	return crypto.SignerOptions{
+		KeySize:    key.KeySize,
+		Curve:      key.Curve,
+		Scheme:     key.Scheme,
+		SaltLength: crypto.SaltLengthOption(key.SaltLength),
+		Hash:       key.Hash,
+	}
+}
+
+// checkVersion returns ErrVersionMismatch if the stored device is not at version ifVersion.
+// A nil ifVersion accepts any version.
+func checkVersion(dto common.DeviceDTO, ifVersion *uint64) error {
+	if ifVersion != nil && *ifVersion != dto.Version {
+		return fmt.Errorf("%w: device %s is at version %d", ErrVersionMismatch, dto.ID, dto.Version)
+	}
+	return nil
+}
+
+// update applies the changes of u to the device at time now. The key material, the signature
+// counter and the chain of signatures are never changed.
+// Returns a ValidationError for an invalid label, metadata or status and
+// ErrInvalidStatusTransition when leaving the decommissioned state.
+func (d *signatureDevice) update(u DeviceUpdate, now time.Time) error {
+	errs := make([]string, 0)
+	if u.Label != nil {
+		errs = append(errs, validateLabel(*u.Label)...)
+	}
+	if u.Metadata != nil {
+		errs = append(errs, validateMetadata(u.Metadata)...)
+	}
+	if len(errs) > 0 {
+		return NewValidationError(errs)
+	}
+
+	if u.Status != nil {
+		if err := d.setStatus(*u.Status, now); err != nil {
+			return err
+		}
+	}
+	if u.Label != nil {
+		// an empty label removes it
+		d.Label = nil
+		if *u.Label != "" {
+			d.Label = copyString(u.Label)
+		}
+	}
+	if u.Metadata != nil {
+		d.metadata = copyMetadata(u.Metadata)
+	}
+	return nil
 }
 
 // Convert a device into a DTO that can be exposed to outside ervices
 func (d *signatureDevice) ToSerializable() common.Device {
+	options := d.signer.Options()
 
-	return common.Device{
-		ID:        d.ID,
-		Algorithm: d.signer.GetAlgorithm(),
-		Label:     copyString(d.Label),
-		PublicKey: d.signer.PublicKey(),
+	device := common.Device{
+		ID:         d.ID,
+		Algorithm:  d.signer.GetAlgorithm(),
+		Label:      copyString(d.Label),
+		Metadata:   copyMetadata(d.metadata),
+		KeySize:    options.KeySize,
+		Curve:      options.Curve,
+		Scheme:     options.Scheme,
+		SaltLength: options.PSSSaltLength(),
+		Hash:       options.Hash,
+		KeyBackend: d.backend(),
+		PublicKey:  d.signer.PublicKey(),
+		KeyVersion: d.keyVersion,
+		KeyHistory: copyKeyHistory(d.keyHistory),
+		Status:     d.status,
+		Version:    d.version,
+	}
+	if d.status == StatusDecommissioned {
+		finalCounter, decommissionedAt := d.finalCounter, d.decommissionedAt
+		device.FinalCounter = &finalCounter
+		device.DecommissionedAt = &decommissionedAt
 	}
+	return device
 }
 
 // Unmarshal a device from its DTO representation
@@ -73,13 +262,41 @@ func deviceFromDTO(dto common.DeviceDTO) (signatureDevice, error) {
 	var d signatureDevice
 	d.ID = dto.ID
 	d.Label = copyString(dto.Label)
+	d.metadata = copyMetadata(dto.Metadata)
 	d.signatureCounter = dto.SignatureCounter
 	d.LastSignature = dto.LastSignature
-	signer, err := unmarshalSigner(dto.Algorithm, dto.PrivateKey)
+	d.keyVersion = dto.KeyVersion
+	if d.keyVersion == 0 {
+		d.keyVersion = 1
+	}
+	d.keyHistory = copyKeyHistory(dto.KeyHistory)
+	d.status = dto.Status
+	if d.status == "" {
+		d.status = StatusActive
+	}
+	d.finalCounter = dto.FinalCounter
+	d.decommissionedAt = dto.DecommissionedAt
+	d.version = dto.Version
+	options := crypto.SignerOptions{
+		KeySize:    dto.KeySize,
+		Curve:      dto.Curve,
+		Scheme:     dto.Scheme,
+		SaltLength: crypto.SaltLengthOption(dto.SaltLength),
+		Hash:       dto.Hash,
+	}
+	var signer crypto.MarshallableSigner
+	var err error
+	if dto.KeyBackend == "" || dto.KeyBackend == crypto.KeyBackendSoftware {
+		signer, err = unmarshalSigner(dto.Algorithm, options, dto.PrivateKey)
+	} else {
+		signer, err = loadBackendSigner(dto.KeyBackend, dto.Algorithm, options, dto.KeyLabel)
+	}
 	if err != nil {
 		return d, fmt.Errorf("Error unmarshalling DTO with algorithm %s (device ID %s): %w", dto.Algorithm, dto.ID, err)
 	}
 	d.signer = signer
+	d.keyBackend = dto.KeyBackend
+	d.keyLabel = dto.KeyLabel
 	return d, nil
 }
 
@@ -91,16 +308,43 @@ func (d signatureDevice) toDTO() common.DeviceDTO {
 		// TODO: handle this
 		panic(err)
 	}
+	options := d.signer.Options()
 
-	return common.DeviceDTO{
+	dto := common.DeviceDTO{
 		ID:               d.ID,
 		Label:            d.Label,
+		Metadata:         copyMetadata(d.metadata),
 		Algorithm:        d.signer.GetAlgorithm(),
+		KeySize:          options.KeySize,
+		Curve:            options.Curve,
+		Scheme:           options.Scheme,
+		SaltLength:       options.PSSSaltLength(),
+		Hash:             options.Hash,
 		PrivateKey:       privateKey,
 		PublicKey:        publicKey,
+		KeyVersion:       d.keyVersion,
+		KeyHistory:       copyKeyHistory(d.keyHistory),
+		Status:           d.status,
+		FinalCounter:     d.finalCounter,
+		DecommissionedAt: d.decommissionedAt,
 		SignatureCounter: d.signatureCounter,
 		LastSignature:    d.LastSignature,
+		Version:          d.version,
 	}
+	if d.backend() != crypto.KeyBackendSoftware {
+		// only a reference to the external key is stored
+		dto.KeyBackend = d.keyBackend
+		dto.KeyLabel = d.keyLabel
+	}
+	return dto
+}
+
+// backend returns the key store holding the private key of the device
+func (d *signatureDevice) backend() string {
+	if d.keyBackend == "" {
+		return crypto.KeyBackendSoftware
+	}
+	return d.keyBackend
 }
 
 func generateDeviceId() string {
@@ -108,15 +352,55 @@ func generateDeviceId() string {
 	return uuid.NewString()
 }
 
-func (d *signatureDevice) composeDataToBeSigned(dataToSign []byte) string {
+// securedData is the data signed by a device: the signature counter, the message and
+// a reference to the previous signature, chaining the signatures of the device
+type securedData struct {
+	// counter is the signature counter of the device before signing
+	counter uint64
+	// dataToBeSigned is the base64 encoded message
+	dataToBeSigned string
+	// previousSignature is the last signature of the device, or the base64 encoded
+	// device ID for the first signature
+	previousSignature string
+}
+
+// String composes the secured data as <counter>_<data_to_be_signed>_<previous_signature>
+func (s securedData) String() string {
+	return fmt.Sprintf("%d_%s_%s", s.counter, s.dataToBeSigned, s.previousSignature)
+}
+
+func (d *signatureDevice) composeDataToBeSigned(dataToSign []byte) securedData {
 	// encode message to b64
 	dataToSignB64 := base64.StdEncoding.EncodeToString(dataToSign)
 
+	previous := d.LastSignature
 	if d.signatureCounter == 0 {
-		deviceIDB64 := base64.StdEncoding.EncodeToString([]byte(d.ID))
-		return fmt.Sprintf("%d_%s_%s", d.signatureCounter, dataToSignB64, deviceIDB64)
+		previous = base64.StdEncoding.EncodeToString([]byte(d.ID))
+	}
+	return securedData{
+		counter:           d.signatureCounter,
+		dataToBeSigned:    dataToSignB64,
+		previousSignature: previous,
+	}
+}
+
+func copyKeyHistory(history []common.RetiredKey) []common.RetiredKey {
+	if history == nil {
+		return nil
+	}
+	return append([]common.RetiredKey{}, history...)
+}
+
+// copyMetadata copies the metadata tags, empty metadata are nil
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		c[key] = value
 	}
-	return fmt.Sprintf("%d_%s_%s", d.signatureCounter, dataToSignB64, d.LastSignature)
+	return c
 }
 
 func copyString(s *string) *string {
@@ -2,8 +2,12 @@ package domain
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
 	"github.com/AloveIs/signing-device-service-go/persistence"
 	"github.com/google/uuid"
 )
@@ -13,31 +17,146 @@ import (
 type DeviceService struct {
 	deviceRepo    persistence.DeviceRepository
 	signatureRepo persistence.SignatureRepository
+	// transactions commits the signatures together with the device updates
+	transactions persistence.TransactionManager
+	keyPolicy    KeyPolicy
+	// keyBackend is the key store of the devices created without choosing one
+	keyBackend string
+	// idPattern accepts client supplied device IDs besides UUIDs, nil if only UUIDs are accepted
+	idPattern *regexp.Regexp
+	// clock timestamps signatures and lifecycle changes
+	clock Clock
 }
 
 func NewDeviceService(devices persistence.DeviceRepository, signatures persistence.SignatureRepository) *DeviceService {
 	return &DeviceService{
 		deviceRepo:    devices,
 		signatureRepo: signatures,
+		transactions:  persistence.NewTransactionManager(devices, signatures),
+		keyPolicy:     DefaultKeyPolicy(),
+		keyBackend:    crypto.KeyBackendSoftware,
+		clock:         systemClock{},
 	}
 }
 
-// CreateDevice creates a new device with the specified signing algorithm and optional label.
+// WithClock sets the clock timestamping signatures and lifecycle changes.
+func (s *DeviceService) WithClock(clock Clock) *DeviceService {
+	s.clock = clock
+	return s
+}
+
+// WithKeyPolicy sets the minimum-strength policy enforced on the keys of new devices.
+func (s *DeviceService) WithKeyPolicy(policy KeyPolicy) *DeviceService {
+	s.keyPolicy = policy
+	return s
+}
+
+// WithDefaultKeyBackend sets the key store of the devices created without choosing one
+// (e.g. crypto.KeyBackendSoftware). The backend must be registered in the crypto package.
+func (s *DeviceService) WithDefaultKeyBackend(backend string) *DeviceService {
+	s.keyBackend = backend
+	return s
+}
+
+// WithDeviceIDPattern accepts the client supplied device IDs matching pattern besides UUIDs.
+// The pattern should be anchored (^...$) to match the whole ID.
+func (s *DeviceService) WithDeviceIDPattern(pattern *regexp.Regexp) *DeviceService {
+	s.idPattern = pattern
+	return s
+}
+
+// CreateDevice creates a new device with the specified signing algorithm, key options and optional label.
+// The key is generated by the default key backend of the service.
 // Returns the created device or an error if the creation fails. If the input values are not
-// wrong a ValidationError is returned.
-func (s *DeviceService) CreateDevice(algorithm string, label *string) (common.Device, error) {
+// wrong or the key does not satisfy the key policy a ValidationError is returned.
+func (s *DeviceService) CreateDevice(algorithm string, options crypto.SignerOptions, label *string) (common.Device, error) {
+	return s.CreateDeviceOnBackend("", "", algorithm, options, label)
+}
 
-	device, err := newDevice(algorithm, label)
+// CreateDeviceOnBackend creates a new device like CreateDevice, generating its key with the
+// key store of backend. An empty backend selects the default one of the service.
+// The device is identified by id, which must be a UUID or match the ID pattern of the service,
+// an empty id generates a new UUID. Returns ErrDeviceAlreadyExists if the ID is taken.
+func (s *DeviceService) CreateDeviceOnBackend(backend string, id string, algorithm string, options crypto.SignerOptions, label *string) (common.Device, error) {
+	if err := s.checkNewDevice(id, label); err != nil {
+		return common.Device{}, err
+	}
+	if backend == "" {
+		backend = s.keyBackend
+	}
+	device, err := newDeviceOnBackend(backend, id, algorithm, options, label)
 	if err != nil {
 		return common.Device{}, err
 	}
-	err = s.deviceRepo.SaveDevice(device.toDTO())
+	if err := s.keyPolicy.check(device.signer); err != nil {
+		deleteBackendKey(device)
+		return common.Device{}, err
+	}
+	err = s.saveNewDevice(device)
 	if err != nil {
+		deleteBackendKey(device)
 		return common.Device{}, err
 	}
 	return device.ToSerializable(), nil
 }
 
+// ImportDevice creates a new device signing with an externally generated private key,
+// PEM encoded (PKCS#1, SEC 1 or PKCS#8). The key must be a key of algorithm, match the
+// key parameters in options if any and satisfy the key policy, otherwise a ValidationError
+// is returned. Imported keys are always kept with the device (software backend).
+// The device ID is chosen as in CreateDeviceOnBackend.
+func (s *DeviceService) ImportDevice(id string, algorithm string, options crypto.SignerOptions, privateKey []byte, label *string) (common.Device, error) {
+	if err := s.checkNewDevice(id, label); err != nil {
+		return common.Device{}, err
+	}
+	device, err := importDevice(id, algorithm, options, privateKey, label)
+	if err != nil {
+		return common.Device{}, err
+	}
+	if err := s.keyPolicy.check(device.signer); err != nil {
+		return common.Device{}, err
+	}
+	err = s.saveNewDevice(device)
+	if err != nil {
+		return common.Device{}, err
+	}
+	return device.ToSerializable(), nil
+}
+
+// checkNewDevice returns a ValidationError if the optional id or label of a new device are
+// invalid and ErrDeviceAlreadyExists if the id is taken, before any key is generated.
+func (s *DeviceService) checkNewDevice(id string, label *string) error {
+	errs := make([]string, 0)
+	if id != "" {
+		errs = append(errs, validateDeviceID(id, s.idPattern)...)
+	}
+	if label != nil {
+		errs = append(errs, validateLabel(*label)...)
+	}
+	if len(errs) > 0 {
+		return NewValidationError(errs)
+	}
+	if id == "" {
+		return nil
+	}
+	_, err := s.deviceRepo.GetDeviceByID(id)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrDeviceAlreadyExists, id)
+	} else if !errors.Is(err, persistence.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// saveNewDevice stores a new device, returns ErrDeviceAlreadyExists if its ID is taken
+func (s *DeviceService) saveNewDevice(device signatureDevice) error {
+	err := s.deviceRepo.SaveDevice(device.toDTO())
+	if errors.Is(err, persistence.ErrIdKeyCollision) {
+		return fmt.Errorf("%w: %s", ErrDeviceAlreadyExists, device.ID)
+	}
+	return err
+}
+
 func (s *DeviceService) GetAllDevices() ([]common.Device, error) {
 	DTOdevices, err := s.deviceRepo.ListDevices()
 	if err != nil {
@@ -55,29 +174,400 @@ func (s *DeviceService) GetAllDevices() ([]common.Device, error) {
 	return result, nil
 }
 
+// DeviceFilter selects devices, empty fields match any device
+type DeviceFilter struct {
+	Algorithm string
+	Label     string
+	Status    string
+}
+
+// QueryDevices returns a page of the devices matching filter, ordered by ID. A zero limit selects
+// DefaultPageSize. The next page starts after cursor, the cursor returned with the previous page.
+// The returned cursor is empty on the last page.
+// Returns a ValidationError for an invalid filter, limit or cursor.
+func (s *DeviceService) QueryDevices(filter DeviceFilter, cursor string, limit int) ([]common.Device, string, error) {
+	errs := validateLimit(limit)
+	if filter.Status != "" && !isDeviceStatus(filter.Status) {
+		errs = append(errs, fmt.Sprintf("status: value must be of values: %s", strings.Join(deviceStatuses, ", ")))
+	}
+	if len(errs) > 0 {
+		return nil, "", NewValidationError(errs)
+	}
+	limit = pageLimit(limit)
+	var after deviceCursor
+	if cursor != "" {
+		if err := decodeCursor(cursor, &after); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// fetch one more device to know if there is a next page
+	DTOdevices, err := s.deviceRepo.QueryDevices(common.DeviceQuery{
+		Algorithm: filter.Algorithm,
+		Label:     filter.Label,
+		Status:    filter.Status,
+		AfterID:   after.ID,
+		Limit:     limit + 1,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(DTOdevices) > limit {
+		DTOdevices = DTOdevices[:limit]
+		next = encodeCursor(deviceCursor{ID: DTOdevices[limit-1].ID})
+	}
+	result := make([]common.Device, 0, len(DTOdevices))
+	for _, DTOd := range DTOdevices {
+		d, err := deviceFromDTO(DTOd)
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, d.ToSerializable())
+	}
+	return result, next, nil
+}
+
+// GetJWKS returns the public keys of the active devices as JSON Web Keys, the retired keys
+// included so that the signatures made before a key rotation stay verifiable.
+// Each key is identified by the device ID and its key version, e.g. "<deviceID>-v2".
+func (s *DeviceService) GetJWKS() ([]crypto.JWK, error) {
+	DTOdevices, err := s.deviceRepo.QueryDevices(common.DeviceQuery{Status: StatusActive})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]crypto.JWK, 0, len(DTOdevices))
+	add := func(publicKey string, options crypto.SignerOptions, kid string) error {
+		jwk, err := crypto.NewJWK(publicKey, options, kid)
+		if errors.Is(err, crypto.ErrUnsupportedAlgorithm) {
+			// keys that have no JWK representation are left out of the set
+			return nil
+		} else if err != nil {
+			return err
+		}
+		result = append(result, jwk)
+		return nil
+	}
+	for _, DTOd := range DTOdevices {
+		d, err := deviceFromDTO(DTOd)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(d.signer.PublicKey(), d.signer.Options(), jwkKeyID(d.ID, d.keyVersion)); err != nil {
+			return nil, err
+		}
+		for _, key := range d.keyHistory {
+			if err := add(key.PublicKey, retiredKeyOptions(key), jwkKeyID(d.ID, key.Version)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// jwkKeyID returns the JWK "kid" of a key version of a device
+func jwkKeyID(deviceID string, keyVersion int) string {
+	return fmt.Sprintf("%s-v%d", deviceID, keyVersion)
+}
+
 // GetDeviceByID retrieves the device with deviceID.
 // If the device is not found ErrDeviceNotFound is returned.
 func (s *DeviceService) GetDeviceByID(deviceID string) (common.Device, error) {
 
+	device, err := s.loadDevice(deviceID)
+	if err != nil {
+		return common.Device{}, err
+	}
+	return device.ToSerializable(), nil
+}
+
+// loadDevice reads the device with deviceID from the repository.
+// If the device is not found ErrDeviceNotFound is returned.
+func (s *DeviceService) loadDevice(deviceID string) (signatureDevice, error) {
 	deviceDTO, err := s.deviceRepo.GetDeviceByID(deviceID)
 	if err != nil && errors.Is(err, persistence.ErrNotFound) {
+		return signatureDevice{}, ErrDeviceNotFound
+	} else if err != nil {
+		return signatureDevice{}, err
+	}
+	return deviceFromDTO(deviceDTO)
+}
+
+// VerifySignature checks that signature has been produced by the device identified by
+// deviceID over signedData with the key of version keyVersion. If keyVersion is zero
+// the current key and then the retired ones are tried.
+// An invalid signature is not an error, it is reported in the result.
+// Returns ErrDeviceNotFound if the device does not exist, a ValidationError if it never had the key version.
+func (s *DeviceService) VerifySignature(deviceID string, signedData []byte, signature []byte, keyVersion int) (common.VerificationResult, error) {
+	device, err := s.loadDevice(deviceID)
+	if err != nil {
+		return common.VerificationResult{}, err
+	}
+
+	versions := []int{keyVersion}
+	if keyVersion == 0 {
+		versions = []int{device.keyVersion}
+		for i := len(device.keyHistory) - 1; i >= 0; i-- {
+			versions = append(versions, device.keyHistory[i].Version)
+		}
+	}
+	for _, version := range versions {
+		verifier, err := device.verifier(version)
+		if err != nil {
+			return common.VerificationResult{}, err
+		}
+		err = verifier.Verify(signedData, signature)
+		if errors.Is(err, crypto.ErrInvalidSignature) {
+			continue
+		} else if err != nil {
+			return common.VerificationResult{}, err
+		}
+		return common.VerificationResult{
+			Valid:      true,
+			Reason:     fmt.Sprintf("signature matches the signed data with the %s key version %d of the device", device.signer.GetAlgorithm(), version),
+			KeyVersion: version,
+		}, nil
+	}
+	return common.VerificationResult{
+		Valid:  false,
+		Reason: fmt.Sprintf("signature does not match the signed data with the %s keys of the device", device.signer.GetAlgorithm()),
+	}, nil
+}
+
+// DeviceUpdate holds the changes to a device, nil fields are left unchanged.
+type DeviceUpdate struct {
+	// Status is the new lifecycle state
+	Status *string
+	// Label is the new label, an empty label removes it
+	Label *string
+	// Metadata replace all the metadata tags, an empty map removes them
+	Metadata map[string]string
+	// IfVersion applies the update only to this version of the device, nil for any version
+	IfVersion *uint64
+}
+
+// UpdateDevice applies update to the device identified by deviceID. The keys and the
+// signature counter of the device cannot be changed.
+// Returns ErrDeviceNotFound if the device does not exist, a ValidationError for an invalid
+// label, metadata or status, ErrInvalidStatusTransition when leaving the decommissioned state
+// and ErrVersionMismatch if the device is not at update.IfVersion.
+func (s *DeviceService) UpdateDevice(deviceID string, update DeviceUpdate) (common.Device, error) {
+	var updated common.Device
+	version, err := s.deviceRepo.TransactionalUpdateDevice(deviceID, func(deviceDTO *common.DeviceDTO) error {
+		if err := checkVersion(*deviceDTO, update.IfVersion); err != nil {
+			return err
+		}
+		device, err := deviceFromDTO(*deviceDTO)
+		if err != nil {
+			return err
+		}
+		if err := device.update(update, s.clock.Now()); err != nil {
+			return err
+		}
+		*deviceDTO = device.toDTO()
+		updated = device.ToSerializable()
+		return nil
+	})
+	if errors.Is(err, persistence.ErrNotFound) {
 		return common.Device{}, ErrDeviceNotFound
+	} else if errors.Is(err, persistence.ErrVersionConflict) {
+		return common.Device{}, ErrVersionMismatch
 	} else if err != nil {
 		return common.Device{}, err
 	}
-	device, err := deviceFromDTO(deviceDTO)
-	if err != nil && errors.Is(err, persistence.ErrNotFound) {
+	updated.Version = version
+	return updated, nil
+}
+
+// UpdateDeviceStatus moves the device identified by deviceID to a new lifecycle status.
+// Decommissioning is irreversible and records the final signature counter and the time.
+// Returns ErrDeviceNotFound if the device does not exist, a ValidationError for unknown
+// states and ErrInvalidStatusTransition when leaving the decommissioned state.
+func (s *DeviceService) UpdateDeviceStatus(deviceID string, status string) (common.Device, error) {
+	return s.UpdateDevice(deviceID, DeviceUpdate{Status: &status})
+}
+
+// RotateDeviceKey generates a new key pair for the device identified by deviceID, with the
+// same algorithm, parameters and key backend. The current key is retired to the key history so
+// that the signatures it produced stay verifiable; the signature counter and chain are preserved.
+// Returns ErrDeviceNotFound if the device does not exist, ErrDeviceNotActive if it is decommissioned.
+func (s *DeviceService) RotateDeviceKey(deviceID string) (common.Device, error) {
+	return s.RotateDeviceKeyIfVersion(deviceID, nil)
+}
+
+// RotateDeviceKeyIfVersion rotates the key of the device like RotateDeviceKey, only if the
+// device is at version ifVersion. A nil ifVersion accepts any version.
+// Returns ErrVersionMismatch if the device is at another version.
+func (s *DeviceService) RotateDeviceKeyIfVersion(deviceID string, ifVersion *uint64) (common.Device, error) {
+	var rotated signatureDevice
+	generated := false
+	version, err := s.deviceRepo.TransactionalUpdateDevice(deviceID, func(deviceDTO *common.DeviceDTO) error {
+		if err := checkVersion(*deviceDTO, ifVersion); err != nil {
+			return err
+		}
+		device, err := deviceFromDTO(*deviceDTO)
+		if err != nil {
+			return err
+		}
+		if device.status == StatusDecommissioned {
+			return fmt.Errorf("%w: device %s is %s", ErrDeviceNotActive, device.ID, device.status)
+		}
+		// like the first key, the new key is stored under a unique label unrelated to the
+		// device ID, which may be supplied by the client
+		label := generateDeviceId()
+		signer, err := newBackendSigner(device.backend(), device.signer.GetAlgorithm(), device.signer.Options(), label)
+		if err != nil {
+			return err
+		}
+		device.rotateKey(signer, label)
+		rotated, generated = device, true
+		if err := s.keyPolicy.check(signer); err != nil {
+			return err
+		}
+		*deviceDTO = device.toDTO()
+		return nil
+	})
+	if err != nil && generated {
+		deleteBackendKey(rotated)
+	}
+	if errors.Is(err, persistence.ErrNotFound) {
 		return common.Device{}, ErrDeviceNotFound
+	} else if errors.Is(err, persistence.ErrVersionConflict) {
+		return common.Device{}, ErrVersionMismatch
+	} else if err != nil {
+		return common.Device{}, err
 	}
-	return device.ToSerializable(), nil
+	rotated.version = version
+	return rotated.ToSerializable(), nil
+}
+
+// ListDeviceSignatures returns a page of the signatures of the device identified by deviceID
+// in the counter range of query, ordered by counter. A zero limit selects DefaultPageSize.
+// The next page starts after cursor, the cursor returned with the previous page, and cannot be
+// combined with an offset. The returned cursor is empty on the last page.
+// Returns ErrDeviceNotFound if the device does not exist and a ValidationError for an invalid query.
+func (s *DeviceService) ListDeviceSignatures(deviceID string, query common.SignatureQuery, cursor string) ([]common.Signature, string, error) {
+	errs := validateLimit(query.Limit)
+	if query.Offset < 0 {
+		errs = append(errs, "offset: value must not be negative")
+	}
+	if query.Offset > 0 && cursor != "" {
+		errs = append(errs, "offset: value cannot be combined with a cursor")
+	}
+	if query.CounterTo != 0 && query.CounterTo <= query.CounterFrom {
+		errs = append(errs, "counter_to: value must be greater than counter_from")
+	}
+	if len(errs) > 0 {
+		return nil, "", NewValidationError(errs)
+	}
+	limit := pageLimit(query.Limit)
+	if cursor != "" {
+		var after counterCursor
+		if err := decodeCursor(cursor, &after); err != nil {
+			return nil, "", err
+		}
+		if after.Counter+1 > query.CounterFrom {
+			query.CounterFrom = after.Counter + 1
+		}
+	}
+
+	if _, err := s.deviceRepo.GetDeviceByID(deviceID); errors.Is(err, persistence.ErrNotFound) {
+		return nil, "", ErrDeviceNotFound
+	} else if err != nil {
+		return nil, "", err
+	}
+	if query.CounterTo != 0 && query.CounterFrom >= query.CounterTo {
+		return []common.Signature{}, "", nil
+	}
+	// fetch one more signature to know if there is a next page
+	query.Limit = limit + 1
+	signatureDTOs, err := s.signatureRepo.QueryDeviceSignatures(deviceID, query)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(signatureDTOs) > limit {
+		signatureDTOs = signatureDTOs[:limit]
+		next = encodeCursor(counterCursor{Counter: signatureDTOs[limit-1].Counter})
+	}
+	signatures := make([]common.Signature, len(signatureDTOs))
+	for i, signatureDTO := range signatureDTOs {
+		signatures[i] = signatureDTO.ToSignature()
+	}
+	return signatures, next, nil
+}
+
+// VerifySignatureChain audits the stored signatures of the device identified by deviceID in
+// counter order, up to the signature counter of the device when the audit starts. The counters
+// must have no gaps or duplicates, every secured data must embed the previous signature (the
+// base64 encoded device ID for counter 0) and every signature must match the device key.
+// Returns the report with the first broken link and ErrDeviceNotFound if the device does not exist.
+func (s *DeviceService) VerifySignatureChain(deviceID string) (common.ChainVerification, error) {
+	deviceDTO, err := s.deviceRepo.GetDeviceByID(deviceID)
+	if errors.Is(err, persistence.ErrNotFound) {
+		return common.ChainVerification{}, ErrDeviceNotFound
+	} else if err != nil {
+		return common.ChainVerification{}, err
+	}
+	device, err := deviceFromDTO(deviceDTO)
+	if err != nil {
+		return common.ChainVerification{}, err
+	}
+
+	report := common.ChainVerification{DeviceID: device.ID, SignatureCounter: device.signatureCounter}
+	chain := newChainVerifier(&device)
+	query := common.SignatureQuery{CounterTo: device.signatureCounter, Limit: MaxPageSize}
+	var lastID string
+	// a zero CounterTo would not bound the query
+	for device.signatureCounter > 0 && report.FirstBrokenLink == nil {
+		signatures, err := s.signatureRepo.QueryDeviceSignatures(device.ID, query)
+		if err != nil {
+			return common.ChainVerification{}, err
+		}
+		// pages start at the counter of the last signature checked, to find its duplicates
+		if len(signatures) > 0 && signatures[0].ID == lastID {
+			signatures = signatures[1:]
+		}
+		if len(signatures) == 0 {
+			break
+		}
+		for _, signature := range signatures {
+			report.SignaturesChecked++
+			if report.FirstBrokenLink, err = chain.check(signature); err != nil {
+				return common.ChainVerification{}, err
+			} else if report.FirstBrokenLink != nil {
+				break
+			}
+		}
+		last := signatures[len(signatures)-1]
+		query.CounterFrom, lastID = last.Counter, last.ID
+	}
+	if report.FirstBrokenLink == nil && chain.next < device.signatureCounter {
+		report.FirstBrokenLink = chain.missing(device.signatureCounter)
+	}
+	report.Valid = report.FirstBrokenLink == nil
+	return report, nil
 }
 
 // SignMessageWithDevice signs a message using the device identified by deviceID.
-// Returns the signature and signed data, or ErrDeviceNotFound if the device does not exist.
+// Returns the signature and signed data, ErrDeviceNotFound if the device does not exist
+// or ErrDeviceNotActive if the device is suspended or decommissioned.
 func (s *DeviceService) SignMessageWithDevice(deviceID string, message []byte) (common.Signature, error) {
+	signature, _, err := s.SignMessageWithDeviceIfVersion(deviceID, message, nil)
+	return signature, err
+}
+
+// SignMessageWithDeviceIfVersion signs a message like SignMessageWithDevice, only if the
+// device is at version ifVersion. A nil ifVersion accepts any version.
+// Returns the signature and the version of the device after signing.
+// Returns ErrVersionMismatch if the device is at another version.
+func (s *DeviceService) SignMessageWithDeviceIfVersion(deviceID string, message []byte, ifVersion *uint64) (common.Signature, uint64, error) {
 	// TODO: make the signature result capture more elegant, e.g. add a result interface{} as second argument of updateFn
 	var signatureDTO common.SignatureDTO
-	err := s.deviceRepo.TransactionalUpdateDevice(deviceID, func(deviceDTO *common.DeviceDTO) error {
+	version, err := s.transactions.UpdateDevice(deviceID, func(deviceDTO *common.DeviceDTO, tx persistence.Transaction) error {
+		if err := checkVersion(*deviceDTO, ifVersion); err != nil {
+			return err
+		}
 		var err error
 		device, err := deviceFromDTO(*deviceDTO)
 		if err != nil {
@@ -91,27 +581,35 @@ func (s *DeviceService) SignMessageWithDevice(deviceID string, message []byte) (
 		// store the signature
 		signatureDTO = common.SignatureDTO{
 			// TODO: move the construction inside a business logic function
-			ID:         uuid.NewString(),
-			DeviceID:   device.ID,
-			Signature:  signature,
-			SignedData: signedData,
+			ID:                uuid.NewString(),
+			DeviceID:          device.ID,
+			Signature:         signature,
+			SignedData:        signedData.String(),
+			Counter:           signedData.counter,
+			DataToBeSigned:    signedData.dataToBeSigned,
+			PreviousSignature: signedData.previousSignature,
+			Algorithm:         device.signer.GetAlgorithm(),
+			Hash:              device.signer.Options().Hash,
+			KeyVersion:        device.keyVersion,
+			CreatedAt:         s.clock.Now().UTC(),
 		}
-		// TODO this can cause deadlock if the interplay between the two inmemory db gets more complicated (there are 2 independent mutexes)
-		err = s.signatureRepo.SaveSignature(signatureDTO)
+		// the signature is committed together with the device
+		err = tx.SaveSignature(signatureDTO)
 
 		if err != nil {
 			return err
 		}
 		// update and device and store it
 		*deviceDTO = device.toDTO()
-		if err != nil {
-			return err
-		}
 		return nil
 	})
 	if errors.Is(err, persistence.ErrNotFound) {
-		return common.Signature{}, ErrDeviceNotFound
+		return common.Signature{}, 0, ErrDeviceNotFound
+	} else if errors.Is(err, persistence.ErrVersionConflict) {
+		return common.Signature{}, 0, ErrVersionMismatch
+	} else if err != nil {
+		return common.Signature{}, 0, err
 	}
 
-	return signatureDTO.ToSignature(), nil
+	return signatureDTO.ToSignature(), version, nil
 }
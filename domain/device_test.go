@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/AloveIs/signing-device-service-go/crypto"
@@ -9,7 +10,7 @@ import (
 // Test that the signature counter gets incremented by one each time
 func TestSignatureDeviceSignatureCounter(t *testing.T) {
 	// Create original device
-	signDevice, err := newDevice("RSA", nil)
+	signDevice, err := newDevice("RSA", crypto.SignerOptions{}, nil)
 	if err != nil {
 		t.Fatalf("Failed to create device: %v", err)
 	}
@@ -36,14 +37,14 @@ func TestSignatureDeviceSignatureCounter(t *testing.T) {
 	}
 }
 
-// Test that both RSA and ECSDA algorithms are supported
+// Test that RSA, ECSDA and Ed25519 algorithms are supported
 func TestSignatureDeviceAlgorithms(t *testing.T) {
-	algorithms := []string{crypto.AlgoRSA, crypto.AlgoECDSA}
+	algorithms := []string{crypto.AlgoRSA, crypto.AlgoECDSA, crypto.AlgoEd25519}
 
 	for _, algo := range algorithms {
 		t.Run(algo, func(t *testing.T) {
 			// Create device with algorithm
-			device, err := newDevice(algo, nil)
+			device, err := newDevice(algo, crypto.SignerOptions{}, nil)
 			if err != nil {
 				t.Fatalf("Failed to create device with %s algorithm: %v", algo, err)
 			}
@@ -62,8 +63,64 @@ func TestSignatureDeviceAlgorithms(t *testing.T) {
 	}
 
 	// Test invalid algorithm
-	_, err := newDevice("INVALID", nil)
+	_, err := newDevice("INVALID", crypto.SignerOptions{}, nil)
 	if err == nil {
 		t.Error("Expected error when creating device with invalid algorithm")
 	}
 }
+
+// namedSigner wraps a built-in signer to emulate an algorithm shipped by another package
+type namedSigner struct {
+	crypto.MarshallableSigner
+	name string
+}
+
+func (s *namedSigner) GetAlgorithm() string {
+	return s.name
+}
+
+// Test that algorithms added to the crypto registry can be used by devices
+// and are listed in the validation message
+func TestSignatureDeviceRegisteredAlgorithm(t *testing.T) {
+	name := "TEST-ED25519"
+	err := crypto.Register(crypto.Algorithm{
+		Name:    name,
+		KeyType: "OKP",
+		New: func(options crypto.SignerOptions) (crypto.MarshallableSigner, error) {
+			signer, err := crypto.NewEd25519Signer(options)
+			return &namedSigner{MarshallableSigner: signer, name: name}, err
+		},
+		Unmarshal: func(privateKey []byte, options crypto.SignerOptions) (crypto.MarshallableSigner, error) {
+			signer, err := crypto.UnmarshalEd25519Signer(privateKey, options)
+			return &namedSigner{MarshallableSigner: signer, name: name}, err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register algorithm: %v", err)
+	}
+	t.Cleanup(func() { crypto.Unregister(name) })
+
+	device, err := newDevice(name, crypto.SignerOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create device with %s algorithm: %v", name, err)
+	}
+
+	// the device must survive a round trip through its DTO
+	restored, err := deviceFromDTO(device.toDTO())
+	if err != nil {
+		t.Fatalf("Failed to restore device: %v", err)
+	}
+	if restored.signer.GetAlgorithm() != name {
+		t.Errorf("Expected algorithm to be %s, got %s", name, restored.signer.GetAlgorithm())
+	}
+
+	// the validation error lists the registered algorithm
+	_, err = newDevice("INVALID", crypto.SignerOptions{}, nil)
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if !strings.Contains(validationErr.Errors[0], name) {
+		t.Errorf("Expected %q to list %s", validationErr.Errors[0], name)
+	}
+}
@@ -3,6 +3,13 @@ package domain
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
 )
 
 // TODO: make this its own struct to be consistent
@@ -10,6 +17,18 @@ import (
 var ErrDeviceNotFound = errors.New("device not found")
 var ErrSignatureNotFound = errors.New("signature not found")
 
+// ErrDeviceAlreadyExists is returned when creating a device with the ID of another device
+var ErrDeviceAlreadyExists = errors.New("device already exists")
+
+// ErrDeviceNotActive is returned when signing with a suspended or decommissioned device
+var ErrDeviceNotActive = errors.New("device is not active")
+
+// ErrInvalidStatusTransition is returned when a device cannot move to the requested status
+var ErrInvalidStatusTransition = errors.New("invalid device status transition")
+
+// ErrVersionMismatch is returned when a device is not at the version a change expects
+var ErrVersionMismatch = errors.New("device version does not match")
+
 type ValidationError struct {
 	Errors []string
 }
@@ -21,3 +40,90 @@ func (e ValidationError) Error() string {
 func NewValidationError(errors []string) *ValidationError {
 	return &ValidationError{Errors: errors}
 }
+
+// Limits of the user defined attributes of a device
+const (
+	maxLabelLength         = 128
+	maxMetadataEntries     = 32
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 256
+)
+
+// metadataKeyPattern is the charset of metadata keys, e.g. "store_id" or "register.serial"
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// maxDeviceIDLength is the maximum length of a client supplied device ID
+const maxDeviceIDLength = 128
+
+// validateDeviceID checks that a client supplied device ID is a UUID or matches pattern,
+// when pattern is not nil. IDs are path segments, they cannot contain '/'. UUIDs must be in
+// their canonical lowercase form, so that the same UUID cannot name two devices.
+// Returns a list of human readable error messages.
+func validateDeviceID(id string, pattern *regexp.Regexp) []string {
+	if parsed, err := uuid.Parse(id); err == nil {
+		if id != parsed.String() {
+			return []string{fmt.Sprintf("id: UUID must be in canonical lowercase form: %s", parsed)}
+		}
+		return nil
+	}
+	if pattern == nil {
+		return []string{"id: value must be a UUID"}
+	}
+	if len(id) > maxDeviceIDLength || strings.Contains(id, "/") || !pattern.MatchString(id) {
+		return []string{fmt.Sprintf("id: value must be a UUID or match %s", pattern)}
+	}
+	return nil
+}
+
+// validateLabel checks that a device label has at most maxLabelLength printable characters.
+// Returns a list of human readable error messages.
+func validateLabel(label string) []string {
+	errors := make([]string, 0)
+	if utf8.RuneCountInString(label) > maxLabelLength {
+		errors = append(errors, fmt.Sprintf("label: value must be at most %d characters", maxLabelLength))
+	}
+	if !isPrintable(label) {
+		errors = append(errors, "label: value must contain only printable characters")
+	}
+	return errors
+}
+
+// validateMetadata checks the number of metadata entries, that keys are made of letters, digits,
+// '_', '.' and '-' and that values are printable, within their length limits.
+// Returns a list of human readable error messages.
+func validateMetadata(metadata map[string]string) []string {
+	errors := make([]string, 0)
+	if len(metadata) > maxMetadataEntries {
+		errors = append(errors, fmt.Sprintf("metadata: at most %d entries are allowed", maxMetadataEntries))
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(key) > maxMetadataKeyLength || !metadataKeyPattern.MatchString(key) {
+			errors = append(errors, fmt.Sprintf("metadata: key %q must be 1 to %d letters, digits, '_', '.' or '-'", key, maxMetadataKeyLength))
+		}
+		value := metadata[key]
+		if utf8.RuneCountInString(value) > maxMetadataValueLength {
+			errors = append(errors, fmt.Sprintf("metadata.%s: value must be at most %d characters", key, maxMetadataValueLength))
+		}
+		if !isPrintable(value) {
+			errors = append(errors, fmt.Sprintf("metadata.%s: value must contain only printable characters", key))
+		}
+	}
+	return errors
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
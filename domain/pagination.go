@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Page sizes of the list operations
+const (
+	// DefaultPageSize is the page size of list operations without a limit
+	DefaultPageSize = 100
+	// MaxPageSize is the largest page size of a list operation
+	MaxPageSize = 1000
+)
+
+// validateLimit checks the page size of a list operation, zero selects DefaultPageSize.
+// Returns a list of human readable error messages.
+func validateLimit(limit int) []string {
+	if limit < 0 || limit > MaxPageSize {
+		return []string{fmt.Sprintf("limit: value must be between 1 and %d", MaxPageSize)}
+	}
+	return nil
+}
+
+// pageLimit returns the page size of limit
+func pageLimit(limit int) int {
+	if limit == 0 {
+		return DefaultPageSize
+	}
+	return limit
+}
+
+// encodeCursor encodes the position of the last item of a page into an opaque cursor
+func encodeCursor(position any) string {
+	data, err := json.Marshal(position)
+	if err != nil {
+		// positions are plain structs
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes a cursor returned by encodeCursor into position.
+// Returns a ValidationError if the cursor is malformed.
+func decodeCursor(cursor string, position any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(position)
+	}
+	if err != nil {
+		return NewValidationError([]string{"cursor: value is not a valid cursor"})
+	}
+	return nil
+}
+
+// deviceCursor is the position of a device in a page of devices
+type deviceCursor struct {
+	ID string `json:"id"`
+}
+
+// counterCursor is the position of a signature in a page of signatures of a device
+type counterCursor struct {
+	Counter uint64 `json:"counter"`
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// KeyPolicy is the server side minimum-strength policy that every new device key
+// must satisfy.
+type KeyPolicy struct {
+	// MinKeySize maps a key type (see crypto.Algorithm.KeyType) to the minimum
+	// key size in bits. Key types without an entry are not restricted.
+	MinKeySize map[string]int
+}
+
+// DefaultKeyPolicy returns the policy applied when none is configured:
+// at least 2048 bits RSA moduli and 256 bits elliptic curves.
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		MinKeySize: map[string]int{
+			"RSA": 2048,
+			"EC":  256,
+		},
+	}
+}
+
+// check verifies that the key of signer satisfies the policy.
+// Returns a ValidationError if the key is too weak.
+func (p KeyPolicy) check(signer crypto.Signer) error {
+	algorithm, err := crypto.Lookup(signer.GetAlgorithm())
+	if err != nil {
+		return err
+	}
+	minSize, has := p.MinKeySize[algorithm.KeyType]
+	if !has {
+		return nil
+	}
+	if size := signer.Options().KeySize; size < minSize {
+		return NewValidationError([]string{
+			fmt.Sprintf("key size %d is below the minimum of %d bits required for %s keys", size, minSize, algorithm.KeyType),
+		})
+	}
+	return nil
+}
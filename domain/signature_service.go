@@ -2,6 +2,7 @@ package domain
 
 import (
 	"errors"
+	"time"
 
 	"github.com/AloveIs/signing-device-service-go/common"
 	"github.com/AloveIs/signing-device-service-go/persistence"
@@ -42,3 +43,63 @@ func (s *SignatureService) ListSignatures() ([]common.Signature, error) {
 	}
 	return result, nil
 }
+
+// SignatureFilter selects signatures, empty fields match any signature
+type SignatureFilter struct {
+	DeviceID string
+	// From is the first creation time included
+	From time.Time
+	// To is the first creation time excluded
+	To time.Time
+}
+
+// signatureCursor is the position of a signature in a page of signatures
+type signatureCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+}
+
+// QuerySignatures returns a page of the signatures matching filter, ordered by creation time and ID.
+// A zero limit selects DefaultPageSize. The next page starts after cursor, the cursor returned with
+// the previous page. The returned cursor is empty on the last page.
+// Returns a ValidationError for an invalid filter, limit or cursor.
+func (s *SignatureService) QuerySignatures(filter SignatureFilter, cursor string, limit int) ([]common.Signature, string, error) {
+	errs := validateLimit(limit)
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		errs = append(errs, "to: value must be after from")
+	}
+	if len(errs) > 0 {
+		return nil, "", NewValidationError(errs)
+	}
+	limit = pageLimit(limit)
+	query := common.SignatureListQuery{
+		DeviceID:    filter.DeviceID,
+		CreatedFrom: filter.From,
+		CreatedTo:   filter.To,
+		// fetch one more signature to know if there is a next page
+		Limit: limit + 1,
+	}
+	if cursor != "" {
+		var after signatureCursor
+		if err := decodeCursor(cursor, &after); err != nil {
+			return nil, "", err
+		}
+		query.After = &common.SignaturePosition{CreatedAt: after.CreatedAt, ID: after.ID}
+	}
+
+	signatureDTOs, err := s.repo.QuerySignatures(query)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(signatureDTOs) > limit {
+		signatureDTOs = signatureDTOs[:limit]
+		last := signatureDTOs[limit-1]
+		next = encodeCursor(signatureCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	result := make([]common.Signature, len(signatureDTOs))
+	for i, sigDTO := range signatureDTOs {
+		result[i] = sigDTO.ToSignature()
+	}
+	return result, next, nil
+}
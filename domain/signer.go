@@ -3,35 +3,116 @@ package domain
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/AloveIs/signing-device-service-go/crypto"
 )
 
 // ErrInvalidAlgorithm is returned when an unsupported signing algorithm is specified
-var ErrInvalidAlgorithm = errors.New(fmt.Sprintf("invalid algorithm: value must be of values: %s, %s", crypto.AlgoECDSA, crypto.AlgoRSA))
-
-// UnmarshalSigner creates a signer from a serialized private key using the specified algorithm
-// It supports RSA and ECDSA algorithms and returns an error for unsupported algorithms
-func unmarshalSigner(algorithm string, privateKey []byte) (crypto.MarshallableSigner, error) {
-	switch algorithm {
-	case crypto.AlgoRSA:
-		return crypto.UnmarshalRSASigner(privateKey)
-	case crypto.AlgoECDSA:
-		return crypto.UnmarshalECDSASigner(privateKey)
-	default:
-		return nil, ErrInvalidAlgorithm
+var ErrInvalidAlgorithm = errors.New("invalid algorithm")
+
+// invalidAlgorithmMessage describes the allowed algorithms, listing whatever is
+// currently registered in the crypto registry.
+func invalidAlgorithmMessage() string {
+	return fmt.Sprintf("%s: value must be of values: %s", ErrInvalidAlgorithm, strings.Join(crypto.RegisteredAlgorithms(), ", "))
+}
+
+// UnmarshalSigner creates a signer from a serialized private key using the specified algorithm and options
+// The algorithm is looked up in the crypto registry and an error is returned if it is not registered
+func unmarshalSigner(algorithm string, options crypto.SignerOptions, privateKey []byte) (crypto.MarshallableSigner, error) {
+	algo, err := crypto.Lookup(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAlgorithm, err)
 	}
+	return algo.Unmarshal(privateKey, options)
 }
 
 // NewSigner creates a new signer instance for the specified algorithm
-// Generates a new key pair and returns a marshallable signer interface
-func newSigner(algorithm string) (crypto.MarshallableSigner, error) {
-	switch algorithm {
-	case crypto.AlgoRSA:
-		return crypto.NewRSASigner()
-	case crypto.AlgoECDSA:
-		return crypto.NewECDSASigner()
-	default:
-		return nil, NewValidationError([]string{ErrInvalidAlgorithm.Error()})
+// Generates a new key pair with the given options and returns a marshallable signer interface
+func newSigner(algorithm string, options crypto.SignerOptions) (crypto.MarshallableSigner, error) {
+	algo, err := crypto.Lookup(algorithm)
+	if err != nil {
+		return nil, NewValidationError([]string{invalidAlgorithmMessage()})
+	}
+	signer, err := algo.New(options)
+	if errors.Is(err, crypto.ErrInvalidOptions) {
+		return nil, NewValidationError([]string{err.Error()})
+	}
+	return signer, err
+}
+
+// newBackendSigner creates a new signer whose key is generated by the key store of backend,
+// stored under label. The software backend generates the key in process with newSigner.
+func newBackendSigner(backend string, algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	if backend == "" || backend == crypto.KeyBackendSoftware {
+		return newSigner(algorithm, options)
+	}
+	store, err := crypto.LookupKeyStore(backend)
+	if err != nil {
+		return nil, NewValidationError([]string{
+			fmt.Sprintf("key_backend: value must be of values: %s", strings.Join(crypto.KeyBackends(), ", ")),
+		})
+	}
+	if _, err := crypto.Lookup(algorithm); err != nil {
+		return nil, NewValidationError([]string{invalidAlgorithmMessage()})
+	}
+	signer, err := store.Generate(algorithm, options, label)
+	if errors.Is(err, crypto.ErrInvalidOptions) {
+		return nil, NewValidationError([]string{err.Error()})
+	}
+	return signer, err
+}
+
+// loadBackendSigner returns the signer of the key stored under label in the key store of backend.
+func loadBackendSigner(backend string, algorithm string, options crypto.SignerOptions, label string) (crypto.MarshallableSigner, error) {
+	store, err := crypto.LookupKeyStore(backend)
+	if err != nil {
+		return nil, err
+	}
+	return store.Load(algorithm, options, label)
+}
+
+// deleteBackendKey destroys the external key of a device that could not be created or rotated.
+func deleteBackendKey(device signatureDevice) error {
+	if device.backend() == crypto.KeyBackendSoftware {
+		return nil
+	}
+	store, err := crypto.LookupKeyStore(device.keyBackend)
+	if err != nil {
+		return err
+	}
+	return store.Delete(device.keyLabel)
+}
+
+// importSigner creates a signer from an externally generated private key of algorithm, PEM encoded.
+// The key parameters in options, if any, must match the key. Keys that cannot be decoded
+// are reported with a ValidationError.
+func importSigner(algorithm string, options crypto.SignerOptions, privateKey []byte) (crypto.MarshallableSigner, error) {
+	algo, err := crypto.Lookup(algorithm)
+	if err != nil {
+		return nil, NewValidationError([]string{invalidAlgorithmMessage()})
+	}
+	signer, err := algo.Unmarshal(privateKey, options)
+	if errors.Is(err, crypto.ErrInvalidOptions) {
+		return nil, NewValidationError([]string{err.Error()})
+	} else if err != nil {
+		return nil, NewValidationError([]string{fmt.Sprintf("private_key: not a valid %s key: %v", algorithm, err)})
+	}
+
+	keyOptions := signer.Options()
+	errs := make([]string, 0)
+	if options.KeySize != 0 && options.KeySize != keyOptions.KeySize {
+		errs = append(errs, fmt.Sprintf("key_size: the private key is of %d bits", keyOptions.KeySize))
+	}
+	if options.Curve != "" && options.Curve != keyOptions.Curve {
+		errs = append(errs, fmt.Sprintf("curve: the private key is on curve %s", keyOptions.Curve))
+	}
+	if len(errs) > 0 {
+		return nil, NewValidationError(errs)
+	}
+	// key rotations generate a new key with the parameters of the imported one
+	if err := crypto.CheckKeyParameters(algorithm, keyOptions); err != nil {
+		return nil, NewValidationError([]string{fmt.Sprintf("private_key: keys of these parameters cannot be rotated: %v", err)})
 	}
+	return signer, nil
 }
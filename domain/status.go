@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lifecycle states of a device
+const (
+	// StatusActive devices can sign
+	StatusActive = "ACTIVE"
+	// StatusSuspended devices cannot sign until they are activated again
+	StatusSuspended = "SUSPENDED"
+	// StatusDecommissioned devices are retired for good, their signatures stay verifiable
+	StatusDecommissioned = "DECOMMISSIONED"
+)
+
+// deviceStatuses lists the valid states, in the order they are reported to clients
+var deviceStatuses = []string{StatusActive, StatusSuspended, StatusDecommissioned}
+
+// setStatus moves the device to status at time now. Decommissioning is irreversible
+// and records the final signature counter. Setting the current status is a no-op.
+// Returns a ValidationError for unknown states and ErrInvalidStatusTransition
+// when leaving the decommissioned state.
+func (d *signatureDevice) setStatus(status string, now time.Time) error {
+	if !isDeviceStatus(status) {
+		return NewValidationError([]string{fmt.Sprintf("status: value must be of values: %s", strings.Join(deviceStatuses, ", "))})
+	}
+	if status == d.status {
+		return nil
+	}
+	if d.status == StatusDecommissioned {
+		return fmt.Errorf("%w: device %s is %s", ErrInvalidStatusTransition, d.ID, StatusDecommissioned)
+	}
+	d.status = status
+	if status == StatusDecommissioned {
+		d.finalCounter = d.signatureCounter
+		d.decommissionedAt = now.UTC()
+	}
+	return nil
+}
+
+// checkActive returns ErrDeviceNotActive if the device cannot sign
+func (d *signatureDevice) checkActive() error {
+	if d.status != StatusActive {
+		return fmt.Errorf("%w: device %s is %s", ErrDeviceNotActive, d.ID, d.status)
+	}
+	return nil
+}
+
+func isDeviceStatus(status string) bool {
+	for _, s := range deviceStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -1,31 +1,133 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/AloveIs/signing-device-service-go/api"
+	"github.com/AloveIs/signing-device-service-go/crypto"
+	"github.com/AloveIs/signing-device-service-go/crypto/hsm"
 	"github.com/AloveIs/signing-device-service-go/domain"
 	"github.com/AloveIs/signing-device-service-go/persistence"
 )
 
 const (
 	ListenAddress = ":8080"
+	// shutdownTimeout is how long the requests in progress are waited for on shutdown
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
-	server := configureServer()
-	if err := server.Run(); err != nil {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	// offline commands
+	if len(os.Args) > 1 && os.Args[1] == "rewrap-keys" {
+		if err := rewrapKeys(config, os.Args[2:]); err != nil {
+			log.Fatal("Could not re-wrap keys: ", err)
+		}
+		return
+	}
+
+	if config.PKCS11 != nil {
+		store, err := hsm.Open(*config.PKCS11)
+		if err != nil {
+			log.Fatal("Could not open the PKCS#11 token: ", err)
+		}
+		defer store.Close()
+		if err := crypto.RegisterKeyStore(hsm.BackendName, store); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	server, closeDb, err := configureServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// stop the server on SIGINT and SIGTERM, then close the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Could not shut down the server: ", err)
+		}
+	}()
+	err = server.Run()
+	if closeErr := closeDb(); closeErr != nil {
+		log.Println("Could not close the database: ", closeErr)
+	}
+	if err != nil {
 		log.Fatal("Could not start server on ", ListenAddress)
 	}
 }
 
-func configureServer() *api.Server {
-	// create the repositories (database)
-	deviceRepo := persistence.NewInMemoryDeviceDb()
-	signatureRepo := persistence.NewInMemorySignatureDb()
+// newRepositories creates the repositories (database) of the service, and the function
+// closing the database.
+func newRepositories(config Config) (persistence.DeviceRepository, persistence.SignatureRepository, func() error, error) {
+	switch config.Database {
+	case DatabaseJournal:
+		journal, err := persistence.OpenJournalDb(config.JournalDir)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not open the journal: %w", err)
+		}
+		journal = journal.WithSnapshotEvery(config.JournalSnapshotEvery)
+		return persistence.NewJournalDeviceDb(journal), persistence.NewJournalSignatureDb(journal), journal.Close, nil
+	case DatabaseSQLite:
+		db, err := persistence.OpenSQLiteDb(config.SQLitePath)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not open the SQLite database: %w", err)
+		}
+		return persistence.NewSQLDeviceDb(db), persistence.NewSQLSignatureDb(db), db.Close, nil
+	case DatabasePostgres:
+		db, err := persistence.OpenPostgresDb(config.PostgresURL)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not open the PostgreSQL database: %w", err)
+		}
+		return persistence.NewSQLDeviceDb(db), persistence.NewSQLSignatureDb(db), db.Close, nil
+	default:
+		noop := func() error { return nil }
+		return persistence.NewInMemoryDeviceDb(), persistence.NewInMemorySignatureDb(), noop, nil
+	}
+}
+
+// configureServer creates the server and its repositories. The returned function closes
+// the database once the server has stopped.
+func configureServer(config Config) (*api.Server, func() error, error) {
+	if config.KEK == nil && !config.InsecurePlaintextKeys {
+		return nil, nil, fmt.Errorf("no key-encryption key configured: set %s or %s, or %s=true to store the private keys in plain",
+			EnvKEKFile, EnvKEK, EnvInsecurePlaintextKeys)
+	}
+	// create the repositories (database), open for the lifetime of the server
+	deviceRepo, signatureRepo, closeDb, err := newRepositories(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.KEK != nil {
+		deviceRepo = persistence.NewEncryptedDeviceDb(deviceRepo, *config.KEK, config.PreviousKEKs...)
+	} else {
+		log.Printf("WARNING: %s is set, private keys are stored in plain", EnvInsecurePlaintextKeys)
+	}
 
 	// configure services (business logic)
 	deviceService := domain.NewDeviceService(deviceRepo, signatureRepo)
+	if config.KeyBackend != "" {
+		deviceService = deviceService.WithDefaultKeyBackend(config.KeyBackend)
+	}
+	if config.DeviceIDPattern != nil {
+		deviceService = deviceService.WithDeviceIDPattern(config.DeviceIDPattern)
+	}
 	signatureService := domain.NewSignatureService(signatureRepo)
 
 	// configure the http server
@@ -35,7 +137,42 @@ func configureServer() *api.Server {
 	server = server.WithHandler("/api/v0/health/", api.NewHealthHandler())
 	server = server.WithHandler("/api/v0/devices/", api.NewDeviceAPIHandler(deviceService))
 	server = server.WithHandler("/api/v0/signatures/", api.NewSignatureAPIHandler(signatureService))
+	server = server.WithHandler("/api/v0/.well-known/", api.NewWellKnownHandler(deviceService))
 
 	// start the server
-	return server
+	return server, closeDb, nil
+}
+
+// rewrapKeys is an offline command re-wrapping the private keys of all the devices
+// under a new key-encryption key. The configured KEKs are used to unwrap the keys.
+func rewrapKeys(config Config, args []string) error {
+	flags := flag.NewFlagSet("rewrap-keys", flag.ContinueOnError)
+	newKEKFile := flags.String("new-kek-file", "", "file containing the new key-encryption key (base64, hex or raw)")
+	newKEKID := flags.String("new-kek-id", "", "identifier of the new key-encryption key (default: key fingerprint)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *newKEKFile == "" {
+		return fmt.Errorf("-new-kek-file is required")
+	}
+	newKEK, err := crypto.LoadKEKFromFile(*newKEKID, *newKEKFile)
+	if err != nil {
+		return err
+	}
+
+	previous := config.PreviousKEKs
+	if config.KEK != nil {
+		previous = append(previous, *config.KEK)
+	}
+	deviceRepo, _, closeDb, err := newRepositories(config)
+	if err != nil {
+		return err
+	}
+	defer closeDb()
+	count, err := persistence.RewrapDeviceKeys(deviceRepo, newKEK, previous...)
+	if err != nil {
+		return err
+	}
+	log.Printf("Re-wrapped the keys of %d devices under KEK %s", count, newKEK.ID)
+	return nil
 }
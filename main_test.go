@@ -2,14 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/AloveIs/signing-device-service-go/api"
 	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
 )
 
 // Perform end-to-end testing performing a sequence of requests mocking the
@@ -17,15 +25,29 @@ import (
 func TestMain(t *testing.T) {
 	// spin-up an instance of the server
 	// TODO: here the repository should be the real database (I usually use testcontainers)
-	server := configureServer()
+	kek, err := crypto.GenerateKEK("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, closeDb, err := configureServer(Config{KEK: &kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb()
+	defer server.Shutdown(context.Background())
+	// the test goroutine fails the test if the server cannot start, t.Fatal must not be
+	// called from the goroutine of the server
+	runErr := make(chan error, 1)
 	go func() {
-		if err := server.Run(); err != nil {
-			t.Fatal(err)
-		}
+		runErr <- server.Run()
 	}()
 	// TODO: add more features to the server to know when it started
 	// wait for the server to start
-	<-time.After(time.Millisecond * 500)
+	select {
+	case err := <-runErr:
+		t.Fatal(err)
+	case <-time.After(time.Millisecond * 500):
+	}
 
 	// N is the number of clients
 	N := 1
@@ -40,25 +62,83 @@ func TestMain(t *testing.T) {
 			deviceA := testCreateDevice(t)
 			deviceB := testCreateDevice(t)
 			testListDevices(t, []common.Device{deviceA, deviceB})
+			testPaginateDevices(t, []common.Device{deviceA, deviceB})
 			testListSignatures(t, []common.Signature{})
 			sigA := testSignMessage(t, deviceA)
 			sigB := testSignMessage(t, deviceB)
 			testListSignatures(t, []common.Signature{sigA, sigB})
+			sigA2 := testSignMessage(t, deviceA)
+			testListDeviceSignatures(t, deviceA, "", []common.Signature{sigA, sigA2})
+			testListDeviceSignatures(t, deviceA, "?counter_from=1", []common.Signature{sigA2})
+			testListDeviceSignatures(t, deviceA, "?limit=1&offset=1", []common.Signature{sigA2})
+			testVerifyChain(t, deviceA, 2)
+			testVerifySignature(t, deviceA, sigA, true)
+			testVerifySignature(t, deviceB, sigA, false)
 			// test retrieve
 			testRetrieveSignature(t, sigA)
+			// every signature increments the version of the device
+			deviceA.Version += 2
 			testRetrieveDevice(t, deviceA)
+			testRetrievePublicKey(t, deviceA)
+			testJWKS(t, []string{deviceA.ID + "-v1", deviceB.ID + "-v1"})
+			rotatedA := testRotateKey(t, deviceA)
+			testVerifySignature(t, rotatedA, sigA, true)
+			testSuspendDevice(t, deviceB)
+			// retired keys stay published, suspended devices are left out
+			testJWKS(t, []string{rotatedA.ID + "-v1", rotatedA.ID + "-v2"})
+			testUpdateDevice(t, rotatedA)
+			testDevicePreconditions(t, deviceB)
+			testSignPreconditions(t, rotatedA)
 			// test error messages
 			testRetrieveDeviceFailure(t, "IMPOSSIBLE_DEVICE_ID")
 			testRetrieveSignatureFailure(t, "IMPOSSIBLE_DEVICE_ID")
 			// test invalid payloads
 			testSignatureFailure(t, deviceA)
-			testDeviceCreationFailure(t)
+			testDeviceCreationFailure(t, deviceA)
 			wg.Done()
 		}()
 	}
 	wg.Wait()
 }
 
+// List the devices one per page following the cursors and check all the expected devices are listed, ordered by ID
+func testPaginateDevices(t *testing.T, expected []common.Device) {
+	ids := make([]string, 0)
+	query := "?limit=1"
+	for pages := 0; pages <= len(expected); pages++ {
+		resp, err := http.Get("http://localhost:8080/api/v0/devices/" + query)
+		if err != nil {
+			t.Errorf("List devices failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status OK, got %v", resp.StatusCode)
+		}
+		var response struct {
+			Data       []common.Device `json:"data"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Errorf("Failed to decode response body: %v", err)
+		}
+		for _, device := range response.Data {
+			ids = append(ids, device.ID)
+		}
+		if response.NextCursor == "" {
+			break
+		}
+		query = "?limit=1&cursor=" + url.QueryEscape(response.NextCursor)
+	}
+
+	expectedIDs := make([]string, len(expected))
+	for i, device := range expected {
+		expectedIDs[i] = device.ID
+	}
+	sort.Strings(expectedIDs)
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("Expected devices %v, got %v", expectedIDs, ids)
+	}
+}
+
 func testListDevices(t *testing.T, expected []common.Device) {
 	resp, err := http.Get("http://localhost:8080/api/v0/devices/")
 	if err != nil {
@@ -113,9 +193,97 @@ func testRetrieveDevice(t *testing.T, expected common.Device) {
 	}
 	device := response.Data
 
-	if device != expected {
+	if !reflect.DeepEqual(device, expected) {
 		t.Errorf("Expected %v ==  %v", device, expected)
 	}
+	if etag := resp.Header.Get("ETag"); etag != fmt.Sprintf(`"%d"`, expected.Version) {
+		t.Errorf("Expected ETag of version %d, got %s", expected.Version, etag)
+	}
+}
+
+// Retrieve the public key of a device both as PEM and DER and check they match
+// the one returned with the device
+func testRetrievePublicKey(t *testing.T, device common.Device) {
+	if device.PublicKey == "" {
+		t.Errorf("Expected device %s to have a public key", device.ID)
+	}
+
+	for _, accept := range []string{"", "application/x-pem-file", "application/pkix-spki"} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/api/v0/devices/"+device.ID+"/public-key", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Retrieve public key failed: %v", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status OK, got %v", resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Failed to read response body: %v", err)
+		}
+
+		block, _ := pem.Decode([]byte(device.PublicKey))
+		if block == nil {
+			t.Fatalf("Failed to decode device public key")
+		}
+		expected := []byte(device.PublicKey)
+		if accept == "application/pkix-spki" {
+			expected = block.Bytes
+		}
+		if !bytes.Equal(body, expected) {
+			t.Errorf("Public key for Accept %q does not match the device public key", accept)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/api/v0/devices/"+device.ID+"/public-key", nil)
+	req.Header.Set("Accept", "image/png")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Retrieve public key failed: %v", err)
+	} else if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("Expected status Not Acceptable, got %v", resp.StatusCode)
+	}
+}
+
+// Get the JWKS document and check it contains exactly the expected key IDs
+func testJWKS(t *testing.T, expected []string) {
+	resp, err := http.Get("http://localhost:8080/api/v0/.well-known/jwks.json")
+	if err != nil {
+		t.Errorf("Get JWKS failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []crypto.JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	if len(jwks.Keys) != len(expected) {
+		t.Errorf("Expected %v keys, got %v", len(expected), len(jwks.Keys))
+	}
+
+	kids := make(map[string]crypto.JWK)
+	for _, key := range jwks.Keys {
+		kids[key.Kid] = key
+	}
+	for _, kid := range expected {
+		key, ok := kids[kid]
+		if !ok {
+			t.Errorf("Missing key %v", kid)
+		} else if key.Kty != "RSA" || key.Alg != "RS256" {
+			t.Errorf("Unexpected key %v: %+v", kid, key)
+		}
+	}
 }
 
 func testRetrieveSignature(t *testing.T, expected common.Signature) {
@@ -194,6 +362,253 @@ func testSignMessage(t *testing.T, device common.Device) common.Signature {
 	return signature
 }
 
+// Suspend a device and check it refuses to sign
+func testSuspendDevice(t *testing.T, device common.Device) {
+	status := "SUSPENDED"
+	jsonValue, err := json.Marshal(api.UpdateDeviceRequest{Status: &status})
+	if err != nil {
+		t.Errorf("Failed to marshal values: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPatch, "http://localhost:8080/api/v0/devices/"+device.ID, bytes.NewBuffer(jsonValue))
+	if err != nil {
+		t.Errorf("Failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Update device failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	// Decode the response body
+	var response struct {
+		Data common.Device `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	if response.Data.Status != status {
+		t.Errorf("Expected status %s, got %s", status, response.Data.Status)
+	}
+
+	message := "message"
+	isb64 := false
+	jsonValue, _ = json.Marshal(api.SignMessageRequest{Message: &message, IsBase64: &isb64})
+	resp, err = http.Post("http://localhost:8080/api/v0/devices/"+device.ID+"/sign", "application/json", bytes.NewBuffer(jsonValue))
+	if err != nil {
+		t.Errorf("Sign message failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status Conflict, got %v", resp.StatusCode)
+	}
+}
+
+// Update the label and the metadata of a device and check nothing else changes
+func testUpdateDevice(t *testing.T, device common.Device) {
+	label := "register 7"
+	metadata := map[string]string{"store_id": "berlin-01"}
+	jsonValue, err := json.Marshal(api.UpdateDeviceRequest{Label: &label, Metadata: metadata})
+	if err != nil {
+		t.Errorf("Failed to marshal values: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPatch, "http://localhost:8080/api/v0/devices/"+device.ID, bytes.NewBuffer(jsonValue))
+	if err != nil {
+		t.Errorf("Failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Update device failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	var response struct {
+		Data common.Device `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	expected := device
+	expected.Label = &label
+	expected.Metadata = metadata
+	expected.Version++
+	if !reflect.DeepEqual(response.Data, expected) {
+		t.Errorf("Expected device %+v, got %+v", expected, response.Data)
+	}
+
+	invalid := map[string]string{"store id": "berlin-01"}
+	jsonValue, _ = json.Marshal(api.UpdateDeviceRequest{Metadata: invalid})
+	req, _ = http.NewRequest(http.MethodPatch, "http://localhost:8080/api/v0/devices/"+device.ID, bytes.NewBuffer(jsonValue))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Update device failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity, got %v", resp.StatusCode)
+	}
+}
+
+// Verify a signature with a device and check the outcome is the expected one
+func testVerifySignature(t *testing.T, device common.Device, signature common.Signature, expected bool) {
+	inputValues := api.VerifySignatureRequest{
+		SignedData: &signature.SignedData,
+		Signature:  &signature.Signature,
+	}
+	jsonValue, err := json.Marshal(inputValues)
+	if err != nil {
+		t.Errorf("Failed to marshal values: %v", err)
+	}
+	resp, err := http.Post("http://localhost:8080/api/v0/devices/"+device.ID+"/verify", "application/json", bytes.NewBuffer(jsonValue))
+	if err != nil {
+		t.Errorf("Verify signature failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	// Decode the response body
+	var response struct {
+		Data common.VerificationResult `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	if response.Data.Valid != expected {
+		t.Errorf("Expected valid to be %v, got %v (%s)", expected, response.Data.Valid, response.Data.Reason)
+	}
+}
+
+// Change a device with If-Match preconditions and check that only the requests matching
+// the ETag of the device are applied
+func testDevicePreconditions(t *testing.T, device common.Device) {
+	resp, err := http.Get("http://localhost:8080/api/v0/devices/" + device.ID)
+	if err != nil {
+		t.Errorf("Retrieve device failed: %v", err)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	update := func(ifMatch string, label string) *http.Response {
+		jsonValue, _ := json.Marshal(api.UpdateDeviceRequest{Label: &label})
+		req, _ := http.NewRequest(http.MethodPatch, "http://localhost:8080/api/v0/devices/"+device.ID, bytes.NewBuffer(jsonValue))
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Update device failed: %v", err)
+		}
+		return resp
+	}
+	resp = update(etag, "first")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+	newETag := resp.Header.Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag, got %s", newETag)
+	}
+
+	// a concurrent update based on the previous version is rejected
+	testCases := []struct {
+		name     string
+		ifMatch  string
+		expected int
+	}{
+		{"stale", etag, http.StatusPreconditionFailed},
+		{"weak", "W/" + newETag, http.StatusPreconditionFailed},
+		{"malformed", "version", http.StatusPreconditionFailed},
+		{"list", etag + ", " + newETag, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		if resp := update(tc.ifMatch, "second"); resp.StatusCode != tc.expected {
+			t.Errorf("%s: expected status %v, got %v", tc.name, tc.expected, resp.StatusCode)
+		}
+	}
+	if resp := update("*", "any"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	message := "message"
+	isb64 := false
+	jsonValue, _ := json.Marshal(api.SignMessageRequest{Message: &message, IsBase64: &isb64})
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/api/v0/devices/"+device.ID+"/sign", bytes.NewBuffer(jsonValue))
+	req.Header.Set("If-Match", newETag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Sign message failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected status Precondition Failed, got %v", resp.StatusCode)
+	}
+}
+
+// Sign twice with If-Match, each time with the ETag returned by the previous request
+func testSignPreconditions(t *testing.T, device common.Device) {
+	resp, err := http.Get("http://localhost:8080/api/v0/devices/" + device.ID)
+	if err != nil {
+		t.Fatalf("Retrieve device failed: %v", err)
+	}
+	etag := resp.Header.Get("ETag")
+
+	message := "message"
+	isb64 := false
+	jsonValue, _ := json.Marshal(api.SignMessageRequest{Message: &message, IsBase64: &isb64})
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/api/v0/devices/"+device.ID+"/sign", bytes.NewBuffer(jsonValue))
+		req.Header.Set("If-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Sign message failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status Created, got %v", resp.StatusCode)
+		}
+		next := resp.Header.Get("ETag")
+		if next == "" || next == etag {
+			t.Fatalf("Expected a new ETag after signing, got %q", next)
+		}
+		etag = next
+	}
+
+	resp, err = http.Get("http://localhost:8080/api/v0/devices/" + device.ID)
+	if err != nil {
+		t.Fatalf("Retrieve device failed: %v", err)
+	}
+	if current := resp.Header.Get("ETag"); current != etag {
+		t.Errorf("Expected the ETag of the last signature %s, got %s", etag, current)
+	}
+}
+
+// Rotate the key of a device and check the previous key is kept in its history
+func testRotateKey(t *testing.T, device common.Device) common.Device {
+	resp, err := http.Post("http://localhost:8080/api/v0/devices/"+device.ID+"/rotate-key", "application/json", nil)
+	if err != nil {
+		t.Errorf("Rotate key failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	// Decode the response body
+	var response struct {
+		Data common.Device `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	rotated := response.Data
+	if rotated.KeyVersion != device.KeyVersion+1 || rotated.PublicKey == device.PublicKey {
+		t.Errorf("Expected a new key of version %d, got version %d", device.KeyVersion+1, rotated.KeyVersion)
+	}
+	if len(rotated.KeyHistory) != 1 || rotated.KeyHistory[0].PublicKey != device.PublicKey {
+		t.Errorf("Expected the previous key in the key history, got %+v", rotated.KeyHistory)
+	}
+	return rotated
+}
+
 // Get the list of signatures and test if the expected length is correct
 // TODO: add test to check the IDs match the created one
 func testListSignatures(t *testing.T, expected []common.Signature) {
@@ -230,6 +645,54 @@ func testListSignatures(t *testing.T, expected []common.Signature) {
 	}
 }
 
+// List the signatures of a device with the given query string and compare them to the expected ones, in order
+func testListDeviceSignatures(t *testing.T, device common.Device, query string, expected []common.Signature) {
+	resp, err := http.Get("http://localhost:8080/api/v0/devices/" + device.ID + "/signatures" + query)
+	if err != nil {
+		t.Errorf("List device signatures failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+	var response struct {
+		Data []common.Signature `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	if !reflect.DeepEqual(response.Data, expected) {
+		t.Errorf("Expected signatures %+v, got %+v", expected, response.Data)
+	}
+
+	resp, err = http.Get("http://localhost:8080/api/v0/devices/" + device.ID + "/signatures?limit=none")
+	if err != nil {
+		t.Errorf("List device signatures failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity, got %v", resp.StatusCode)
+	}
+}
+
+// Audit the chain of signatures of a device and check it is valid with the expected number of signatures
+func testVerifyChain(t *testing.T, device common.Device, signatures uint64) {
+	resp, err := http.Get("http://localhost:8080/api/v0/devices/" + device.ID + "/chain/verify")
+	if err != nil {
+		t.Errorf("Verify chain failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+	var response struct {
+		Data common.ChainVerification `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	if !response.Data.Valid || response.Data.SignaturesChecked != signatures {
+		t.Errorf("Expected a valid chain of %d signatures, got %+v", signatures, response.Data)
+	}
+}
+
 func testCreateDevice(t *testing.T) common.Device {
 	inputValues := api.CreateDeviceRequest{
 		Label:     nil,
@@ -327,7 +790,8 @@ func testSignatureFailure(t *testing.T, device common.Device) {
 	}
 }
 
-func testDeviceCreationFailure(t *testing.T) {
+// Test invalid device creation requests, existing is a device already created
+func testDeviceCreationFailure(t *testing.T, existing common.Device) {
 	testCases := []struct {
 		jsonMessage   string
 		expctedStatus int
@@ -337,6 +801,9 @@ func testDeviceCreationFailure(t *testing.T) {
 		{`{"algorithm": null}`, http.StatusUnprocessableEntity},
 		{`{"algorithm": "AES", "label": "some-label"}`, http.StatusUnprocessableEntity},
 		{`{"algorithm": "AES", "label": null}`, http.StatusUnprocessableEntity},
+		{`{"algorithm": "ECC", "id": ""}`, http.StatusUnprocessableEntity},
+		{`{"algorithm": "ECC", "id": "register-7"}`, http.StatusUnprocessableEntity},
+		{`{"algorithm": "ECC", "id": "` + existing.ID + `"}`, http.StatusConflict},
 	}
 
 	// run test cases
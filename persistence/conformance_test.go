@@ -0,0 +1,29 @@
+package persistence_test
+
+import (
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/persistence"
+	"github.com/AloveIs/signing-device-service-go/persistence/persistencetest"
+)
+
+// TestRepositoryConformance runs the conformance tests against every backend
+func TestRepositoryConformance(t *testing.T) {
+	for _, backend := range persistence.Backends() {
+		backend := backend
+		t.Run(backend.Name, func(t *testing.T) {
+			t.Run("devices", func(t *testing.T) {
+				persistencetest.TestDeviceRepository(t, func(t *testing.T) persistence.DeviceRepository {
+					devices, _ := backend.Open(t)
+					return devices
+				})
+			})
+			t.Run("signatures", func(t *testing.T) {
+				persistencetest.TestSignatureRepository(t, func(t *testing.T) persistence.SignatureRepository {
+					_, signatures := backend.Open(t)
+					return signatures
+				})
+			})
+		})
+	}
+}
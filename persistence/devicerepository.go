@@ -6,7 +6,8 @@ import (
 
 // DeviceRepository handles CRUD operations for devices
 type DeviceRepository interface {
-	// CreateDevice adds a new device to the repository
+	// CreateDevice adds a new device to the repository, with version 1
+	// Returns ErrIdKeyCollision if a device with the same ID exists
 	SaveDevice(device common.DeviceDTO) error
 
 	// GetDeviceByID fetches a device by ID
@@ -14,9 +15,31 @@ type DeviceRepository interface {
 	GetDeviceByID(id string) (common.DeviceDTO, error)
 
 	// TransactionalUpdateDevice modifies a device within a SQL-like transaction with
-	// the provided updateFn function.
-	TransactionalUpdateDevice(id string, updateFn func(device *common.DeviceDTO) error) error
+	// the provided updateFn function. Concurrent updates of the same device are serialized,
+	// nothing is written if updateFn fails and its error is returned.
+	// The version of the device is incremented when the update is committed: updateFn
+	// gets the stored version, setting another one (e.g. the version a client read)
+	// fails the update with ErrVersionConflict. Returns the version that has been committed.
+	// Returns ErrNotFound if the device is not found, without calling updateFn
+	TransactionalUpdateDevice(id string, updateFn func(device *common.DeviceDTO) error) (uint64, error)
 
 	// ListDevices returns all devices
 	ListDevices() ([]common.DeviceDTO, error)
+
+	// QueryDevices returns the devices matching the filters of query, ordered by ID
+	// and paginated after the AfterID and with the limit of query
+	QueryDevices(query common.DeviceQuery) ([]common.DeviceDTO, error)
+}
+
+// initialVersion is the version of the devices when they are saved
+const initialVersion = 1
+
+// nextVersion checks that the updated device has the stored version and increments it.
+// Returns ErrVersionConflict if the version has been changed.
+func nextVersion(stored uint64, device *common.DeviceDTO) error {
+	if device.Version != stored {
+		return ErrVersionConflict
+	}
+	device.Version++
+	return nil
 }
@@ -0,0 +1,210 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// ErrUnknownKEK is returned when a device key is wrapped with a KEK that is not available.
+var ErrUnknownKEK = errors.New("unknown key-encryption key")
+
+// EncryptedDeviceDb decorates a DeviceRepository encrypting the private keys of
+// the devices at rest. The domain reads and writes plain keys, while the wrapped
+// repository only ever sees keys encrypted with envelope encryption under the KEK.
+type EncryptedDeviceDb struct {
+	repo DeviceRepository
+	// kek encrypts the keys written to the repository
+	kek crypto.KEK
+	// keks holds all the KEKs that can decrypt the stored keys, by ID
+	keks map[string]crypto.KEK
+}
+
+// NewEncryptedDeviceDb wraps repo encrypting the private keys with kek.
+// The previous KEKs are only used to decrypt keys that have not been re-wrapped yet.
+// Keys stored in plain are still readable and get encrypted at their next update.
+func NewEncryptedDeviceDb(repo DeviceRepository, kek crypto.KEK, previous ...crypto.KEK) DeviceRepository {
+	keks := map[string]crypto.KEK{kek.ID: kek}
+	for _, k := range previous {
+		if _, has := keks[k.ID]; !has {
+			keks[k.ID] = k
+		}
+	}
+	return &EncryptedDeviceDb{
+		repo: repo,
+		kek:  kek,
+		keks: keks,
+	}
+}
+
+func (db *EncryptedDeviceDb) SaveDevice(device common.DeviceDTO) error {
+	encrypted, err := encryptDevice(db.kek, device)
+	if err != nil {
+		return err
+	}
+	return db.repo.SaveDevice(encrypted)
+}
+
+func (db *EncryptedDeviceDb) GetDeviceByID(id string) (common.DeviceDTO, error) {
+	device, err := db.repo.GetDeviceByID(id)
+	if err != nil {
+		return common.DeviceDTO{}, err
+	}
+	return decryptDevice(db.keks, device)
+}
+
+func (db *EncryptedDeviceDb) TransactionalUpdateDevice(id string, updateFn func(device *common.DeviceDTO) error) (uint64, error) {
+	return db.repo.TransactionalUpdateDevice(id, func(stored *common.DeviceDTO) error {
+		return db.update(stored, updateFn)
+	})
+}
+
+// update applies updateFn to the decrypted stored device and encrypts it back
+func (db *EncryptedDeviceDb) update(stored *common.DeviceDTO, updateFn func(device *common.DeviceDTO) error) error {
+	device, err := decryptDevice(db.keks, *stored)
+	if err != nil {
+		return err
+	}
+	plainKey := device.PrivateKey
+	if err := updateFn(&device); err != nil {
+		return err
+	}
+	// the private key rarely changes, keep the stored ciphertext if it is
+	// unchanged and already wrapped with the current KEK
+	if stored.KEKID == db.kek.ID && bytes.Equal(device.PrivateKey, plainKey) {
+		device.PrivateKey = stored.PrivateKey
+		device.KEKID = stored.KEKID
+		device.WrappedKey = stored.WrappedKey
+		*stored = device
+		return nil
+	}
+	encrypted, err := encryptDevice(db.kek, device)
+	if err != nil {
+		return err
+	}
+	*stored = encrypted
+	return nil
+}
+
+func (db *EncryptedDeviceDb) ListDevices() ([]common.DeviceDTO, error) {
+	devices, err := db.repo.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i, device := range devices {
+		if devices[i], err = decryptDevice(db.keks, device); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+func (db *EncryptedDeviceDb) QueryDevices(query common.DeviceQuery) ([]common.DeviceDTO, error) {
+	devices, err := db.repo.QueryDevices(query)
+	if err != nil {
+		return nil, err
+	}
+	for i, device := range devices {
+		if devices[i], err = decryptDevice(db.keks, device); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// encryptDevice returns a copy of device with the private key encrypted under kek.
+// The device ID is authenticated with the key, so ciphertexts cannot be swapped between devices.
+// Devices without private key material (e.g. keys held by an HSM) are returned as they are.
+func encryptDevice(kek crypto.KEK, device common.DeviceDTO) (common.DeviceDTO, error) {
+	if len(device.PrivateKey) == 0 {
+		return device, nil
+	}
+	ciphertext, wrappedKey, err := kek.Seal(device.PrivateKey, []byte(device.ID))
+	if err != nil {
+		return common.DeviceDTO{}, fmt.Errorf("failed to encrypt key of device %s: %w", device.ID, err)
+	}
+	device.PrivateKey = ciphertext
+	device.KEKID = kek.ID
+	device.WrappedKey = wrappedKey
+	return device, nil
+}
+
+// decryptDevice returns a copy of device with the plain private key.
+// Devices stored without a KEK are returned as they are.
+func decryptDevice(keks map[string]crypto.KEK, device common.DeviceDTO) (common.DeviceDTO, error) {
+	if device.KEKID == "" {
+		return device, nil
+	}
+	kek, has := keks[device.KEKID]
+	if !has {
+		return common.DeviceDTO{}, fmt.Errorf("%w: %s (device ID %s)", ErrUnknownKEK, device.KEKID, device.ID)
+	}
+	plaintext, err := kek.Open(device.PrivateKey, device.WrappedKey, []byte(device.ID))
+	if err != nil {
+		return common.DeviceDTO{}, fmt.Errorf("failed to decrypt key of device %s: %w", device.ID, err)
+	}
+	device.PrivateKey = plaintext
+	device.KEKID = ""
+	device.WrappedKey = nil
+	return device, nil
+}
+
+// RewrapDeviceKeys re-wraps the keys of all the devices in repo under newKEK.
+// repo must be the undecorated repository. The data-encryption keys are unwrapped
+// with the KEKs in previous, keys stored in plain are encrypted. The private keys
+// themselves are not re-encrypted.
+// Returns the number of devices that have been updated.
+func RewrapDeviceKeys(repo DeviceRepository, newKEK crypto.KEK, previous ...crypto.KEK) (int, error) {
+	keks := make(map[string]crypto.KEK, len(previous))
+	for _, k := range previous {
+		keks[k.ID] = k
+	}
+
+	devices, err := repo.ListDevices()
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, device := range devices {
+		if device.KEKID == newKEK.ID || len(device.PrivateKey) == 0 {
+			continue
+		}
+		_, err := repo.TransactionalUpdateDevice(device.ID, func(stored *common.DeviceDTO) error {
+			if stored.KEKID == newKEK.ID {
+				return nil
+			}
+			if stored.KEKID == "" {
+				encrypted, err := encryptDevice(newKEK, *stored)
+				if err != nil {
+					return err
+				}
+				*stored = encrypted
+				return nil
+			}
+			kek, has := keks[stored.KEKID]
+			if !has {
+				return fmt.Errorf("%w: %s (device ID %s)", ErrUnknownKEK, stored.KEKID, stored.ID)
+			}
+			aad := []byte(stored.ID)
+			dek, err := kek.Unwrap(stored.WrappedKey, aad)
+			if err != nil {
+				return fmt.Errorf("failed to unwrap key of device %s: %w", stored.ID, err)
+			}
+			wrappedKey, err := newKEK.Wrap(dek, aad)
+			if err != nil {
+				return err
+			}
+			stored.KEKID = newKEK.ID
+			stored.WrappedKey = wrappedKey
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -8,3 +8,6 @@ var ErrNotFound = errors.New("record not found")
 
 // ErrIdKeyCollision is returned when trying to create a resource with the same ID.
 var ErrIdKeyCollision = errors.New("id key collision")
+
+// ErrVersionConflict is returned when writing a device whose version is not the stored one.
+var ErrVersionConflict = errors.New("version conflict")
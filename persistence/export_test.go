@@ -0,0 +1,18 @@
+package persistence
+
+import "testing"
+
+// Backend opens the repositories of a backend, for the tests of package persistence_test
+type Backend struct {
+	Name string
+	Open func(t *testing.T) (DeviceRepository, SignatureRepository)
+}
+
+// Backends returns every backend of the repositories
+func Backends() []Backend {
+	backends := make([]Backend, len(transactionBackends))
+	for i, backend := range transactionBackends {
+		backends[i] = Backend{Name: backend.name, Open: backend.open}
+	}
+	return backends
+}
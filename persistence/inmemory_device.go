@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/AloveIs/signing-device-service-go/common"
@@ -13,6 +14,8 @@ type InMemoryDeviceDb struct {
 	rwmutex sync.RWMutex
 	// Storage method is a map deviceID:device
 	db map[string]common.DeviceDTO
+	// journal persists the writes, nil if the database is only in memory
+	journal *JournalDb
 }
 
 func (imdb *InMemoryDeviceDb) GetDeviceByID(deviceID string) (common.DeviceDTO, error) {
@@ -28,6 +31,7 @@ func (imdb *InMemoryDeviceDb) GetDeviceByID(deviceID string) (common.DeviceDTO,
 }
 
 func (imdb *InMemoryDeviceDb) SaveDevice(device common.DeviceDTO) error {
+	defer imdb.journal.compactIfDue()
 	imdb.rwmutex.Lock()
 	defer imdb.rwmutex.Unlock()
 	deviceID := device.ID
@@ -36,11 +40,16 @@ func (imdb *InMemoryDeviceDb) SaveDevice(device common.DeviceDTO) error {
 	if has {
 		return ErrIdKeyCollision
 	}
+	device.Version = initialVersion
+	if err := imdb.journal.append(journalRecord{Devices: []common.DeviceDTO{device}}); err != nil {
+		return err
+	}
 	imdb.db[deviceID] = device
 	return nil
 }
 
 func (imdb *InMemoryDeviceDb) UpdateDevice(key string, val common.DeviceDTO) (common.DeviceDTO, error) {
+	defer imdb.journal.compactIfDue()
 	imdb.rwmutex.Lock()
 	defer imdb.rwmutex.Unlock()
 
@@ -48,26 +57,37 @@ func (imdb *InMemoryDeviceDb) UpdateDevice(key string, val common.DeviceDTO) (co
 	if has {
 		return common.DeviceDTO{}, ErrNotFound
 	}
+	if err := imdb.journal.append(journalRecord{Devices: []common.DeviceDTO{val}}); err != nil {
+		return common.DeviceDTO{}, err
+	}
 	imdb.db[key] = val
 
 	return prev_val, nil
 }
 
-func (imdb *InMemoryDeviceDb) TransactionalUpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO) error) error {
+func (imdb *InMemoryDeviceDb) TransactionalUpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO) error) (uint64, error) {
+	defer imdb.journal.compactIfDue()
 	imdb.rwmutex.Lock()
 	defer imdb.rwmutex.Unlock()
 
 	device, has := imdb.db[deviceID]
 	if !has {
-		return ErrNotFound
+		return 0, ErrNotFound
 	}
+	stored := device.Version
 	if err := updateFn(&device); err != nil {
-		return err
+		return 0, err
+	}
+	if err := nextVersion(stored, &device); err != nil {
+		return 0, err
+	}
+	if err := imdb.journal.append(journalRecord{Devices: []common.DeviceDTO{device}}); err != nil {
+		return 0, err
 	}
 	// perform the database update
 	imdb.db[deviceID] = device
 
-	return nil
+	return device.Version, nil
 }
 
 func (imdb *InMemoryDeviceDb) ListDevices() ([]common.DeviceDTO, error) {
@@ -83,6 +103,25 @@ func (imdb *InMemoryDeviceDb) ListDevices() ([]common.DeviceDTO, error) {
 	return result, nil
 }
 
+func (imdb *InMemoryDeviceDb) QueryDevices(query common.DeviceQuery) ([]common.DeviceDTO, error) {
+	imdb.rwmutex.RLock()
+	defer imdb.rwmutex.RUnlock()
+
+	result := make([]common.DeviceDTO, 0)
+	for _, record := range imdb.db {
+		if query.Matches(record) {
+			result = append(result, record)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	if query.Limit > 0 && query.Limit < len(result) {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
 func NewInMemoryDeviceDb() DeviceRepository {
 	return &InMemoryDeviceDb{
 		db: make(map[string]common.DeviceDTO),
@@ -3,6 +3,7 @@ package persistence
 // TODO: add other tests to verify the other interface functions
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 
@@ -89,3 +90,50 @@ func TestDevivceCreateListRetrieve(t *testing.T) {
 	}
 
 }
+
+// TestQueryDevices tests devices are filtered, ordered by ID and paginated after an ID
+func TestQueryDevices(t *testing.T) {
+	db := NewInMemoryDeviceDb()
+
+	label := "register"
+	devices := []common.DeviceDTO{
+		{ID: "c", Algorithm: "RSA", Status: "ACTIVE"},
+		{ID: "a", Algorithm: "ECC", Status: "ACTIVE", Label: &label},
+		{ID: "d", Algorithm: "ECC", Status: "SUSPENDED"},
+		{ID: "b", Algorithm: "ECC", Status: "ACTIVE"},
+	}
+	for _, device := range devices {
+		if err := db.SaveDevice(device); err != nil {
+			t.Errorf("Cannot create device: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		query    common.DeviceQuery
+		expected []string
+	}{
+		{"all", common.DeviceQuery{}, []string{"a", "b", "c", "d"}},
+		{"limit", common.DeviceQuery{Limit: 2}, []string{"a", "b"}},
+		{"after", common.DeviceQuery{AfterID: "b", Limit: 2}, []string{"c", "d"}},
+		{"algorithm", common.DeviceQuery{Algorithm: "ECC"}, []string{"a", "b", "d"}},
+		{"label", common.DeviceQuery{Label: label}, []string{"a"}},
+		{"status", common.DeviceQuery{Status: "SUSPENDED"}, []string{"d"}},
+		{"algorithm after", common.DeviceQuery{Algorithm: "ECC", AfterID: "a", Limit: 1}, []string{"b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := db.QueryDevices(tc.query)
+			if err != nil {
+				t.Fatalf("Cannot query devices: %v", err)
+			}
+			ids := make([]string, len(result))
+			for i, device := range result {
+				ids[i] = device.ID
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("Expected devices %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/AloveIs/signing-device-service-go/common"
@@ -13,12 +14,22 @@ type InMemorySignatureDb struct {
 	rwmutex sync.RWMutex
 	// Storage method is a map signatureID:signature
 	db map[string]common.SignatureDTO
+	// journal persists the writes, nil if the database is only in memory
+	journal *JournalDb
 }
 
 func (db *InMemorySignatureDb) SaveSignature(signature common.SignatureDTO) error {
+	defer db.journal.compactIfDue()
 	db.rwmutex.Lock()
 	defer db.rwmutex.Unlock()
 
+	if _, has := db.db[signature.ID]; has {
+		return ErrIdKeyCollision
+	}
+	if err := db.journal.append(journalRecord{Signatures: []common.SignatureDTO{signature}}); err != nil {
+		return err
+	}
+
 	db.db[signature.ID] = signature
 	return nil
 }
@@ -57,6 +68,53 @@ func (db *InMemorySignatureDb) GetSignaturesByDeviceID(deviceID string) ([]commo
 	return signatures, nil
 }
 
+func (db *InMemorySignatureDb) QueryDeviceSignatures(deviceID string, query common.SignatureQuery) ([]common.SignatureDTO, error) {
+	db.rwmutex.RLock()
+	defer db.rwmutex.RUnlock()
+
+	signatures := make([]common.SignatureDTO, 0)
+	for _, signature := range db.db {
+		if signature.DeviceID == deviceID && query.Matches(signature) {
+			signatures = append(signatures, signature)
+		}
+	}
+	sort.Slice(signatures, func(i, j int) bool {
+		if signatures[i].Counter != signatures[j].Counter {
+			return signatures[i].Counter < signatures[j].Counter
+		}
+		return signatures[i].ID < signatures[j].ID
+	})
+
+	if query.Offset >= len(signatures) {
+		return signatures[:0], nil
+	}
+	signatures = signatures[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(signatures) {
+		signatures = signatures[:query.Limit]
+	}
+	return signatures, nil
+}
+
+func (db *InMemorySignatureDb) QuerySignatures(query common.SignatureListQuery) ([]common.SignatureDTO, error) {
+	db.rwmutex.RLock()
+	defer db.rwmutex.RUnlock()
+
+	signatures := make([]common.SignatureDTO, 0)
+	for _, signature := range db.db {
+		if query.Matches(signature) {
+			signatures = append(signatures, signature)
+		}
+	}
+	sort.Slice(signatures, func(i, j int) bool {
+		position := common.SignaturePosition{CreatedAt: signatures[i].CreatedAt, ID: signatures[i].ID}
+		return position.Before(signatures[j])
+	})
+	if query.Limit > 0 && query.Limit < len(signatures) {
+		signatures = signatures[:query.Limit]
+	}
+	return signatures, nil
+}
+
 func NewInMemorySignatureDb() SignatureRepository {
 	return &InMemorySignatureDb{
 		db: make(map[string]common.SignatureDTO),
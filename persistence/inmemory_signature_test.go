@@ -1,7 +1,10 @@
 package persistence
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/AloveIs/signing-device-service-go/common"
 )
@@ -78,3 +81,95 @@ func TestSignatureCreateListRetrieve(t *testing.T) {
 		t.Errorf("Expected %v signatures, got %v", len(idsToCreateDeviceB), len(signaturesB))
 	}
 }
+
+// TestQueryDeviceSignatures tests the signatures of a device are filtered by counter range,
+// ordered by counter and paginated
+func TestQueryDeviceSignatures(t *testing.T) {
+	db := NewInMemorySignatureDb()
+
+	// store the signatures out of order, with a signature of another device
+	for _, counter := range []uint64{3, 0, 4, 1, 2} {
+		err := db.SaveSignature(common.SignatureDTO{ID: fmt.Sprintf("A%d", counter), DeviceID: "A", Counter: counter})
+		if err != nil {
+			t.Errorf("Cannot create signature: %v", err)
+		}
+	}
+	if err := db.SaveSignature(common.SignatureDTO{ID: "B0", DeviceID: "B", Counter: 0}); err != nil {
+		t.Errorf("Cannot create signature: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		query    common.SignatureQuery
+		expected []string
+	}{
+		{"all", common.SignatureQuery{}, []string{"A0", "A1", "A2", "A3", "A4"}},
+		{"limit", common.SignatureQuery{Limit: 2}, []string{"A0", "A1"}},
+		{"offset", common.SignatureQuery{Offset: 2, Limit: 2}, []string{"A2", "A3"}},
+		{"offset past the end", common.SignatureQuery{Offset: 5}, []string{}},
+		{"counter range", common.SignatureQuery{CounterFrom: 1, CounterTo: 3}, []string{"A1", "A2"}},
+		{"counter range and offset", common.SignatureQuery{CounterFrom: 2, Offset: 1}, []string{"A3", "A4"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signatures, err := db.QueryDeviceSignatures("A", tc.query)
+			if err != nil {
+				t.Fatalf("Cannot query signatures: %v", err)
+			}
+			ids := make([]string, len(signatures))
+			for i, signature := range signatures {
+				ids[i] = signature.ID
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("Expected signatures %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+// TestQuerySignatures tests signatures are filtered by device and creation time,
+// ordered by creation time and ID and paginated after a position
+func TestQuerySignatures(t *testing.T) {
+	db := NewInMemorySignatureDb()
+
+	start := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	signatures := []common.SignatureDTO{
+		{ID: "3", DeviceID: "A", CreatedAt: start.Add(time.Second)},
+		{ID: "1", DeviceID: "B", CreatedAt: start},
+		{ID: "4", DeviceID: "B", CreatedAt: start.Add(2 * time.Second)},
+		{ID: "2", DeviceID: "A", CreatedAt: start.Add(time.Second)},
+	}
+	for _, signature := range signatures {
+		if err := db.SaveSignature(signature); err != nil {
+			t.Errorf("Cannot create signature: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		query    common.SignatureListQuery
+		expected []string
+	}{
+		{"all", common.SignatureListQuery{}, []string{"1", "2", "3", "4"}},
+		{"limit", common.SignatureListQuery{Limit: 2}, []string{"1", "2"}},
+		{"after", common.SignatureListQuery{After: &common.SignaturePosition{CreatedAt: start.Add(time.Second), ID: "2"}}, []string{"3", "4"}},
+		{"device", common.SignatureListQuery{DeviceID: "B"}, []string{"1", "4"}},
+		{"from", common.SignatureListQuery{CreatedFrom: start.Add(time.Second)}, []string{"2", "3", "4"}},
+		{"to", common.SignatureListQuery{CreatedTo: start.Add(time.Second)}, []string{"1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := db.QuerySignatures(tc.query)
+			if err != nil {
+				t.Fatalf("Cannot query signatures: %v", err)
+			}
+			ids := make([]string, len(result))
+			for i, signature := range result {
+				ids[i] = signature.ID
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("Expected signatures %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package persistence
+
+import "github.com/AloveIs/signing-device-service-go/common"
+
+// inMemoryTransactionManager commits units of work on InMemoryDeviceDb and
+// InMemorySignatureDb while holding the write locks of both databases, always
+// taken in the same order: devices first, then signatures. If the databases are
+// journaled, the unit of work is appended as a single record.
+type inMemoryTransactionManager struct {
+	devices    *InMemoryDeviceDb
+	signatures *InMemorySignatureDb
+}
+
+func (m *inMemoryTransactionManager) UpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO, tx Transaction) error) (uint64, error) {
+	defer m.devices.journal.compactIfDue()
+	m.devices.rwmutex.Lock()
+	defer m.devices.rwmutex.Unlock()
+
+	device, has := m.devices.db[deviceID]
+	if !has {
+		return 0, ErrNotFound
+	}
+	stored := device.Version
+	tx := &pendingTransaction{}
+	if err := updateFn(&device, tx); err != nil {
+		return 0, err
+	}
+	if err := nextVersion(stored, &device); err != nil {
+		return 0, err
+	}
+
+	m.signatures.rwmutex.Lock()
+	defer m.signatures.rwmutex.Unlock()
+
+	// check every write before performing any
+	pending := make(map[string]bool, len(tx.signatures))
+	for _, signature := range tx.signatures {
+		if _, has := m.signatures.db[signature.ID]; has || pending[signature.ID] {
+			return 0, ErrIdKeyCollision
+		}
+		pending[signature.ID] = true
+	}
+
+	for _, signature := range tx.signatures {
+		m.signatures.db[signature.ID] = signature
+	}
+	if err := m.persist(device, tx.signatures); err != nil {
+		// roll back the signatures, nobody could read them while the lock is held
+		for _, signature := range tx.signatures {
+			delete(m.signatures.db, signature.ID)
+		}
+		return 0, err
+	}
+	m.devices.db[deviceID] = device
+	return device.Version, nil
+}
+
+// persist appends the unit of work to the journal, if any
+func (m *inMemoryTransactionManager) persist(device common.DeviceDTO, signatures []common.SignatureDTO) error {
+	return m.devices.journal.append(journalRecord{
+		Devices:    []common.DeviceDTO{device},
+		Signatures: signatures,
+	})
+}
@@ -0,0 +1,360 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+const (
+	// journalFile holds the records appended since the last snapshot
+	journalFile = "journal"
+	// snapshotFile holds the whole state at the time of the last snapshot
+	snapshotFile = "snapshot"
+	// recordHeaderSize is the size of the length and of the checksum preceding a record
+	recordHeaderSize = 8
+	// DefaultSnapshotEvery is the default number of records between two snapshots
+	DefaultSnapshotEvery = 10000
+)
+
+// errTornRecord is returned when a record is truncated or does not match its checksum
+var errTornRecord = errors.New("torn journal record")
+
+// ErrJournalCorrupted is returned when opening a journal with a damaged record followed by
+// other records. Only the last record can be torn by a crash, the journal is left untouched.
+var ErrJournalCorrupted = errors.New("journal corrupted")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// journalRecord is a write to the journal, or the whole state in a snapshot
+type journalRecord struct {
+	// Seq is the sequence number of the record, or of the last record included in a snapshot
+	Seq        uint64                `json:"seq"`
+	Devices    []common.DeviceDTO    `json:"devices,omitempty"`
+	Signatures []common.SignatureDTO `json:"signatures,omitempty"`
+}
+
+// JournalDb persists the devices and the signatures of an InMemoryDeviceDb and an
+// InMemorySignatureDb in an append-only journal. Every write is appended as a record
+// and fsynced before it is applied in memory, the writes of a unit of work go in a single
+// record. Each record is framed by its length and its CRC-32C checksum, so that a record
+// torn by a crash is detected and discarded.
+// The state is periodically written to a snapshot, and the journal is then emptied.
+// On startup the snapshot and the journal are replayed to rebuild the maps.
+type JournalDb struct {
+	dir        string
+	devices    *InMemoryDeviceDb
+	signatures *InMemorySignatureDb
+
+	// mutex guards the journal file and the counters below, it is always taken after
+	// the locks of the in-memory databases
+	mutex sync.Mutex
+	file  *os.File
+	// size is the size of the valid records of the journal file
+	size int64
+	// failure is set if the journal could not be restored after a failed append,
+	// it fails all the following writes
+	failure error
+	// seq is the sequence number of the last record
+	seq uint64
+	// snapshotEvery is the number of records between two snapshots, 0 to disable them
+	snapshotEvery int
+	// sinceSnapshot is the number of records appended since the last snapshot
+	sinceSnapshot int
+}
+
+// OpenJournalDb opens (or creates) the journal in dir and replays it. A torn record at the
+// end of the journal, left by a crash while it was written, is discarded.
+func OpenJournalDb(dir string) (*JournalDb, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	journal := &JournalDb{
+		dir:           dir,
+		snapshotEvery: DefaultSnapshotEvery,
+	}
+	journal.devices = &InMemoryDeviceDb{db: make(map[string]common.DeviceDTO), journal: journal}
+	journal.signatures = &InMemorySignatureDb{db: make(map[string]common.SignatureDTO), journal: journal}
+
+	if err := journal.replay(); err != nil {
+		if journal.file != nil {
+			journal.file.Close()
+		}
+		return nil, err
+	}
+	return journal, nil
+}
+
+// WithSnapshotEvery sets the number of records between two snapshots, 0 disables the
+// periodic snapshots.
+func (j *JournalDb) WithSnapshotEvery(records int) *JournalDb {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.snapshotEvery = records
+	return j
+}
+
+// NewJournalDeviceDb returns the device repository of the journal
+func NewJournalDeviceDb(journal *JournalDb) DeviceRepository {
+	return journal.devices
+}
+
+// NewJournalSignatureDb returns the signature repository of the journal
+func NewJournalSignatureDb(journal *JournalDb) SignatureRepository {
+	return journal.signatures
+}
+
+// Close closes the journal file
+func (j *JournalDb) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
+
+// replay loads the snapshot and applies the records of the journal that follow it
+func (j *JournalDb) replay() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, snapshotFile))
+	if err == nil {
+		snapshot, _, err := decodeRecord(data)
+		if err != nil {
+			return fmt.Errorf("corrupted snapshot: %w", err)
+		}
+		j.apply(snapshot)
+		j.seq = snapshot.Seq
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	j.file, err = os.OpenFile(filepath.Join(j.dir, journalFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	data, err = io.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	for offset < len(data) {
+		record, size, err := decodeRecord(data[offset:])
+		if errors.Is(err, errTornRecord) {
+			if !isLastRecord(data[offset:]) {
+				// the records after it were acknowledged, they must not be dropped
+				return fmt.Errorf("%w: damaged record at offset %d followed by other records", ErrJournalCorrupted, offset)
+			}
+			// the crash happened while the record was appended, it was never acknowledged
+			if err := j.file.Truncate(int64(offset)); err != nil {
+				return err
+			}
+			if err := j.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += size
+		j.size = int64(offset)
+		// records already included in the snapshot, left by a crash during the compaction
+		if record.Seq <= j.seq {
+			continue
+		}
+		j.apply(record)
+		j.seq = record.Seq
+		j.sinceSnapshot++
+	}
+	return nil
+}
+
+// apply writes the record to the in-memory maps
+func (j *JournalDb) apply(record journalRecord) {
+	for _, device := range record.Devices {
+		j.devices.db[device.ID] = device
+	}
+	for _, signature := range record.Signatures {
+		j.signatures.db[signature.ID] = signature
+	}
+}
+
+// append writes the record to the journal and fsyncs it. It does nothing on a nil journal,
+// so that the in-memory databases can call it unconditionally.
+func (j *JournalDb) append(record journalRecord) error {
+	if j == nil {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.failure != nil {
+		return j.failure
+	}
+	record.Seq = j.seq + 1
+	data, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(data)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// drop the partial record, the records appended after it would be lost on replay
+		if truncateErr := j.file.Truncate(j.size); truncateErr != nil {
+			j.failure = fmt.Errorf("journal unusable after a failed append: %w", truncateErr)
+		}
+		return err
+	}
+	j.size += int64(len(data))
+	j.seq = record.Seq
+	j.sinceSnapshot++
+	return nil
+}
+
+// compactIfDue takes a snapshot if enough records have been appended since the last one.
+// It must be called without holding the locks of the in-memory databases. A failed snapshot
+// is logged, it leaves the journal untouched and is retried after the next record.
+func (j *JournalDb) compactIfDue() {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	due := j.snapshotEvery > 0 && j.sinceSnapshot >= j.snapshotEvery
+	j.mutex.Unlock()
+	if due {
+		if err := j.Snapshot(); err != nil {
+			log.Printf("WARNING: journal snapshot failed, the journal keeps growing: %v", err)
+		}
+	}
+}
+
+// Snapshot writes the whole state to the snapshot file and empties the journal.
+// Writes are blocked while the snapshot is taken.
+func (j *JournalDb) Snapshot() error {
+	j.devices.rwmutex.RLock()
+	defer j.devices.rwmutex.RUnlock()
+	j.signatures.rwmutex.RLock()
+	defer j.signatures.rwmutex.RUnlock()
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	snapshot := journalRecord{
+		Seq:        j.seq,
+		Devices:    make([]common.DeviceDTO, 0, len(j.devices.db)),
+		Signatures: make([]common.SignatureDTO, 0, len(j.signatures.db)),
+	}
+	for _, device := range j.devices.db {
+		snapshot.Devices = append(snapshot.Devices, device)
+	}
+	for _, signature := range j.signatures.db {
+		snapshot.Signatures = append(snapshot.Signatures, signature)
+	}
+	data, err := encodeRecord(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := j.replaceFile(snapshotFile, data); err != nil {
+		return err
+	}
+
+	// the records of the old journal are in the snapshot: if the process crashes before
+	// the journal is replaced, they are skipped on replay by their sequence number
+	if err := j.replaceFile(journalFile, nil); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(j.dir, journalFile), os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	j.size = 0
+	j.sinceSnapshot = 0
+	// the snapshot holds the state without the failed writes
+	j.failure = nil
+	return nil
+}
+
+// replaceFile atomically replaces the file name of the journal directory with data
+func (j *JournalDb) replaceFile(name string, data []byte) error {
+	temporary, err := os.CreateTemp(j.dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(data); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), filepath.Join(j.dir, name)); err != nil {
+		return err
+	}
+	// persist the rename
+	dir, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// encodeRecord frames the JSON encoded record with its length and its checksum
+func encodeRecord(record journalRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	buffer.Grow(recordHeaderSize + len(payload))
+	binary.Write(&buffer, binary.BigEndian, uint32(len(payload)))
+	binary.Write(&buffer, binary.BigEndian, crc32.Checksum(payload, crcTable))
+	buffer.Write(payload)
+	return buffer.Bytes(), nil
+}
+
+// isLastRecord reports whether the record at the beginning of data runs to the end of data
+func isLastRecord(data []byte) bool {
+	if len(data) < recordHeaderSize {
+		return true
+	}
+	length := binary.BigEndian.Uint32(data[0:4])
+	return uint64(len(data)-recordHeaderSize) <= uint64(length)
+}
+
+// decodeRecord decodes the record at the beginning of data and returns its size with the
+// framing. It returns errTornRecord if the record is truncated or its checksum does not match.
+func decodeRecord(data []byte) (journalRecord, int, error) {
+	if len(data) < recordHeaderSize {
+		return journalRecord{}, 0, errTornRecord
+	}
+	length := binary.BigEndian.Uint32(data[0:4])
+	checksum := binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-recordHeaderSize) < uint64(length) {
+		return journalRecord{}, 0, errTornRecord
+	}
+	payload := data[recordHeaderSize : recordHeaderSize+int(length)]
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return journalRecord{}, 0, errTornRecord
+	}
+	var record journalRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return journalRecord{}, 0, fmt.Errorf("invalid journal record: %w", err)
+	}
+	return record, recordHeaderSize + int(length), nil
+}
@@ -0,0 +1,280 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+// openTestJournalDb opens the journal in dir, closed at the end of the test
+func openTestJournalDb(t *testing.T, dir string) *JournalDb {
+	t.Helper()
+	journal, err := OpenJournalDb(dir)
+	if err != nil {
+		t.Fatalf("Cannot open the journal: %v", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+// signJournal signs with the device through the journaled repositories, as the domain does
+func signJournal(t *testing.T, journal *JournalDb, deviceID string) {
+	t.Helper()
+	transactions := NewTransactionManager(NewJournalDeviceDb(journal), NewJournalSignatureDb(journal))
+	_, err := transactions.UpdateDevice(deviceID, func(device *common.DeviceDTO, tx Transaction) error {
+		device.SignatureCounter++
+		return tx.SaveSignature(common.SignatureDTO{
+			ID:       fmt.Sprintf("%s-%d", deviceID, device.SignatureCounter),
+			DeviceID: deviceID,
+			Counter:  device.SignatureCounter,
+		})
+	})
+	if err != nil {
+		t.Fatalf("Cannot sign: %v", err)
+	}
+}
+
+// journalState returns the devices and the signatures of the journal, sorted by ID
+func journalState(t *testing.T, journal *JournalDb) ([]common.DeviceDTO, []common.SignatureDTO) {
+	t.Helper()
+	devices, err := NewJournalDeviceDb(journal).QueryDevices(common.DeviceQuery{})
+	if err != nil {
+		t.Fatalf("Cannot list devices: %v", err)
+	}
+	signatures, err := NewJournalSignatureDb(journal).ListSignatures()
+	if err != nil {
+		t.Fatalf("Cannot list signatures: %v", err)
+	}
+	sort.Slice(signatures, func(i, j int) bool { return signatures[i].ID < signatures[j].ID })
+	return devices, signatures
+}
+
+// TestJournalReplay verifies that the state is rebuilt from the journal on startup
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	journal := openTestJournalDb(t, dir)
+	label := "front"
+	if err := NewJournalDeviceDb(journal).SaveDevice(common.DeviceDTO{ID: "1", Label: &label, PrivateKey: []byte("key")}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	signJournal(t, journal, "1")
+	signJournal(t, journal, "1")
+	_, err := NewJournalDeviceDb(journal).TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+		device.Status = "SUSPENDED"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot update device: %v", err)
+	}
+	// a failed update is not journaled
+	failure := errors.New("failure")
+	_, err = NewJournalDeviceDb(journal).TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+		device.Status = "DECOMMISSIONED"
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("Expected %v, got %v", failure, err)
+	}
+	devices, signatures := journalState(t, journal)
+	journal.Close()
+
+	reopened := openTestJournalDb(t, dir)
+	replayedDevices, replayedSignatures := journalState(t, reopened)
+	if !reflect.DeepEqual(replayedDevices, devices) || !reflect.DeepEqual(replayedSignatures, signatures) {
+		t.Errorf("Expected %+v and %+v, got %+v and %+v", devices, signatures, replayedDevices, replayedSignatures)
+	}
+	if len(devices) != 1 || devices[0].Status != "SUSPENDED" || devices[0].SignatureCounter != 2 || len(signatures) != 2 {
+		t.Errorf("Unexpected state %+v, %+v", devices, signatures)
+	}
+	if err := NewJournalDeviceDb(reopened).SaveDevice(common.DeviceDTO{ID: "1"}); err != ErrIdKeyCollision {
+		t.Errorf("Expected %v, got %v", ErrIdKeyCollision, err)
+	}
+}
+
+// TestJournalTornWrite verifies that a record torn by a crash is discarded on startup,
+// and that the journal stays usable
+func TestJournalTornWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		// tear corrupts the last record of the journal
+		tear func(data []byte, last int) []byte
+	}{
+		{"truncated header", func(data []byte, last int) []byte { return data[:last+recordHeaderSize-3] }},
+		{"truncated payload", func(data []byte, last int) []byte { return data[:len(data)-5] }},
+		{"checksum mismatch", func(data []byte, last int) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			journal := openTestJournalDb(t, dir)
+			if err := NewJournalDeviceDb(journal).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+				t.Fatalf("Cannot create device: %v", err)
+			}
+			signJournal(t, journal, "1")
+			path := filepath.Join(dir, journalFile)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := int(info.Size())
+			signJournal(t, journal, "1")
+			journal.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, test.tear(data, last), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			reopened := openTestJournalDb(t, dir)
+			devices, signatures := journalState(t, reopened)
+			if len(devices) != 1 || devices[0].SignatureCounter != 1 || len(signatures) != 1 {
+				t.Fatalf("Expected the state before the torn record, got %+v and %+v", devices, signatures)
+			}
+			if info, _ := os.Stat(path); info.Size() != int64(last) {
+				t.Errorf("Expected the journal truncated to %d bytes, got %d", last, info.Size())
+			}
+
+			// the journal keeps working after the recovery
+			signJournal(t, reopened, "1")
+			reopened.Close()
+			devices, signatures = journalState(t, openTestJournalDb(t, dir))
+			if len(devices) != 1 || devices[0].SignatureCounter != 2 || len(signatures) != 2 {
+				t.Errorf("Expected 2 signatures after the recovery, got %+v and %+v", devices, signatures)
+			}
+		})
+	}
+}
+
+// TestJournalCorruptedRecord verifies that a damaged record followed by other records fails
+// the opening of the journal, instead of dropping the acknowledged records after it
+func TestJournalCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	journal := openTestJournalDb(t, dir)
+	if err := NewJournalDeviceDb(journal).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	signJournal(t, journal, "1")
+	signJournal(t, journal, "1")
+	journal.Close()
+
+	path := filepath.Join(dir, journalFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip the last byte of the second record, a signature
+	_, first, err := decodeRecord(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := decodeRecord(data[first:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[first+second-1] ^= 0xff
+	if err := os.WriteFile(path, corrupted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenJournalDb(dir); !errors.Is(err, ErrJournalCorrupted) {
+		t.Fatalf("Expected %v, got %v", ErrJournalCorrupted, err)
+	}
+	if after, _ := os.ReadFile(path); !reflect.DeepEqual(after, corrupted) {
+		t.Errorf("Expected the journal to be left untouched, got %d bytes instead of %d", len(after), len(corrupted))
+	}
+}
+
+// TestJournalSnapshot verifies the periodic snapshots and the compaction of the journal
+func TestJournalSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	journal := openTestJournalDb(t, dir).WithSnapshotEvery(10)
+	if err := NewJournalDeviceDb(journal).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	N := 25
+	for i := 0; i < N; i++ {
+		signJournal(t, journal, "1")
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("Expected a snapshot: %v", err)
+	}
+	// 26 records, the last snapshot included the first 20
+	if records := countJournalRecords(t, dir); records != 6 {
+		t.Errorf("Expected 6 records in the journal, got %d", records)
+	}
+	devices, signatures := journalState(t, journal)
+	journal.Close()
+
+	reopened := openTestJournalDb(t, dir)
+	replayedDevices, replayedSignatures := journalState(t, reopened)
+	if !reflect.DeepEqual(replayedDevices, devices) || !reflect.DeepEqual(replayedSignatures, signatures) {
+		t.Errorf("Expected the state before the restart, got %+v and %d signatures", replayedDevices, len(replayedSignatures))
+	}
+	if len(signatures) != N {
+		t.Errorf("Expected %d signatures, got %d", N, len(signatures))
+	}
+}
+
+// TestJournalCompactionCrash verifies that the records of a journal that has not been
+// emptied after its snapshot, because of a crash, are not applied twice
+func TestJournalCompactionCrash(t *testing.T) {
+	dir := t.TempDir()
+	journal := openTestJournalDb(t, dir).WithSnapshotEvery(0)
+	if err := NewJournalDeviceDb(journal).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	signJournal(t, journal, "1")
+	signJournal(t, journal, "1")
+	old, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Snapshot(); err != nil {
+		t.Fatalf("Cannot take snapshot: %v", err)
+	}
+	journal.Close()
+	// the crash happened after the snapshot, before the journal was emptied
+	if err := os.WriteFile(filepath.Join(dir, journalFile), old, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestJournalDb(t, dir)
+	signJournal(t, reopened, "1")
+	reopened.Close()
+
+	devices, signatures := journalState(t, openTestJournalDb(t, dir))
+	if len(devices) != 1 || devices[0].SignatureCounter != 3 || len(signatures) != 3 {
+		t.Errorf("Expected 3 signatures, got %+v and %+v", devices, signatures)
+	}
+}
+
+// countJournalRecords returns the number of records in the journal of dir
+func countJournalRecords(t *testing.T, dir string) int {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for offset := 0; offset < len(data); count++ {
+		_, size, err := decodeRecord(data[offset:])
+		if err != nil {
+			t.Fatalf("Invalid record at %d: %v", offset, err)
+		}
+		offset += size
+	}
+	return count
+}
@@ -0,0 +1,413 @@
+// Package persistencetest implements the conformance tests of the repositories: every
+// backend of package persistence must pass them.
+package persistencetest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/persistence"
+)
+
+// concurrentUpdates is the number of concurrent updates of the serialization test
+const concurrentUpdates = 100
+
+// errUpdate is returned by the update functions that must roll back
+var errUpdate = errors.New("update failed")
+
+// DeviceRepositoryFactory returns an empty device repository, released at the end of the test
+type DeviceRepositoryFactory func(t *testing.T) persistence.DeviceRepository
+
+// SignatureRepositoryFactory returns an empty signature repository, released at the end of the test
+type SignatureRepositoryFactory func(t *testing.T) persistence.SignatureRepository
+
+// TestDeviceRepository verifies that the repositories created by newRepository fulfil the
+// contract of persistence.DeviceRepository. Each subtest uses a new repository.
+func TestDeviceRepository(t *testing.T, newRepository DeviceRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository persistence.DeviceRepository)
+	}{
+		{"round trip", testDeviceRoundTrip},
+		{"not found", testDeviceNotFound},
+		{"key collision", testDeviceKeyCollision},
+		{"transactional update", testDeviceTransactionalUpdate},
+		{"rollback", testDeviceRollback},
+		{"versions", testDeviceVersions},
+		{"concurrent updates", testDeviceConcurrentUpdates},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepository(t))
+		})
+	}
+}
+
+// TestSignatureRepository verifies that the repositories created by newRepository fulfil
+// the contract of persistence.SignatureRepository. Each subtest uses a new repository.
+func TestSignatureRepository(t *testing.T, newRepository SignatureRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository persistence.SignatureRepository)
+	}{
+		{"round trip", testSignatureRoundTrip},
+		{"not found", testSignatureNotFound},
+		{"key collision", testSignatureKeyCollision},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepository(t))
+		})
+	}
+}
+
+// testDevice returns a device with every field of the contract set, as it is stored
+func testDevice(id string) common.DeviceDTO {
+	label := "label " + id
+	return common.DeviceDTO{
+		ID:               id,
+		Label:            &label,
+		Metadata:         map[string]string{"store": "berlin"},
+		Algorithm:        "ECC",
+		Curve:            "P-384",
+		PrivateKey:       []byte("private key " + id),
+		PublicKey:        []byte("public key " + id),
+		Status:           "ACTIVE",
+		SignatureCounter: 3,
+		LastSignature:    "last signature",
+		Version:          1,
+	}
+}
+
+// getDevice returns the stored device, failing the test if it cannot be read
+func getDevice(t *testing.T, repository persistence.DeviceRepository, id string) common.DeviceDTO {
+	t.Helper()
+	device, err := repository.GetDeviceByID(id)
+	if err != nil {
+		t.Fatalf("Cannot get device %s: %v", id, err)
+	}
+	return device
+}
+
+func testDeviceRoundTrip(t *testing.T, repository persistence.DeviceRepository) {
+	devices, err := repository.ListDevices()
+	if err != nil {
+		t.Fatalf("Cannot list devices: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("Expected an empty repository, got %d devices", len(devices))
+	}
+
+	ids := []string{"b", "a", "c"}
+	for _, id := range ids {
+		if err := repository.SaveDevice(testDevice(id)); err != nil {
+			t.Fatalf("Cannot create device: %v", err)
+		}
+	}
+	for _, id := range ids {
+		if device := getDevice(t, repository, id); !reflect.DeepEqual(device, testDevice(id)) {
+			t.Errorf("Expected %+v, got %+v", testDevice(id), device)
+		}
+	}
+
+	devices, err = repository.ListDevices()
+	if err != nil {
+		t.Fatalf("Cannot list devices: %v", err)
+	}
+	listed := make([]string, len(devices))
+	for i, device := range devices {
+		listed[i] = device.ID
+	}
+	sort.Strings(listed)
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Expected devices %v, got %v", expected, listed)
+	}
+}
+
+func testDeviceNotFound(t *testing.T, repository persistence.DeviceRepository) {
+	if _, err := repository.GetDeviceByID("missing"); err != persistence.ErrNotFound {
+		t.Errorf("Expected %v, got %v", persistence.ErrNotFound, err)
+	}
+
+	called := false
+	_, err := repository.TransactionalUpdateDevice("missing", func(device *common.DeviceDTO) error {
+		called = true
+		return nil
+	})
+	if err != persistence.ErrNotFound {
+		t.Errorf("Expected %v, got %v", persistence.ErrNotFound, err)
+	}
+	if called {
+		t.Error("Expected updateFn not to be called on a missing device")
+	}
+	if _, err := repository.GetDeviceByID("missing"); err != persistence.ErrNotFound {
+		t.Errorf("Expected the update not to create the device, got %v", err)
+	}
+}
+
+func testDeviceKeyCollision(t *testing.T, repository persistence.DeviceRepository) {
+	if err := repository.SaveDevice(testDevice("1")); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	duplicate := testDevice("1")
+	duplicate.Algorithm = "RSA"
+	if err := repository.SaveDevice(duplicate); err != persistence.ErrIdKeyCollision {
+		t.Errorf("Expected %v, got %v", persistence.ErrIdKeyCollision, err)
+	}
+	if device := getDevice(t, repository, "1"); !reflect.DeepEqual(device, testDevice("1")) {
+		t.Errorf("Expected the first device to be kept, got %+v", device)
+	}
+}
+
+func testDeviceTransactionalUpdate(t *testing.T, repository persistence.DeviceRepository) {
+	for _, id := range []string{"1", "2"} {
+		if err := repository.SaveDevice(testDevice(id)); err != nil {
+			t.Fatalf("Cannot create device: %v", err)
+		}
+	}
+	label := "updated"
+	version, err := repository.TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+		if !reflect.DeepEqual(*device, testDevice("1")) {
+			t.Errorf("Expected updateFn to get %+v, got %+v", testDevice("1"), *device)
+		}
+		device.Label = &label
+		device.SignatureCounter++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot update device: %v", err)
+	}
+
+	expected := testDevice("1")
+	expected.Label = &label
+	expected.SignatureCounter++
+	expected.Version++
+	if device := getDevice(t, repository, "1"); !reflect.DeepEqual(device, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, device)
+	}
+	if version != expected.Version {
+		t.Errorf("Expected the committed version %d, got %d", expected.Version, version)
+	}
+	if device := getDevice(t, repository, "2"); !reflect.DeepEqual(device, testDevice("2")) {
+		t.Errorf("Expected the other device to be unchanged, got %+v", device)
+	}
+}
+
+func testDeviceRollback(t *testing.T, repository persistence.DeviceRepository) {
+	if err := repository.SaveDevice(testDevice("1")); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	_, err := repository.TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+		device.Status = "SUSPENDED"
+		device.SignatureCounter++
+		device.PrivateKey = []byte("changed")
+		return errUpdate
+	})
+	if !errors.Is(err, errUpdate) {
+		t.Errorf("Expected %v, got %v", errUpdate, err)
+	}
+	if device := getDevice(t, repository, "1"); !reflect.DeepEqual(device, testDevice("1")) {
+		t.Errorf("Expected the device to be unchanged, got %+v", device)
+	}
+}
+
+func testDeviceVersions(t *testing.T, repository persistence.DeviceRepository) {
+	// the repository sets the version of new devices
+	device := testDevice("1")
+	device.Version = 7
+	if err := repository.SaveDevice(device); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	if device := getDevice(t, repository, "1"); device.Version != 1 {
+		t.Fatalf("Expected version 1, got %d", device.Version)
+	}
+
+	tests := []struct {
+		name string
+		// updateFn is applied to the device, that has the version expected by the test
+		updateFn        func(device *common.DeviceDTO) error
+		expectedErr     error
+		expectedVersion uint64
+	}{
+		{"update", func(device *common.DeviceDTO) error {
+			device.SignatureCounter++
+			return nil
+		}, nil, 2},
+		{"unchanged device", func(device *common.DeviceDTO) error { return nil }, nil, 3},
+		{"failed update", func(device *common.DeviceDTO) error { return errUpdate }, errUpdate, 3},
+		{"stale version", func(device *common.DeviceDTO) error {
+			device.SignatureCounter++
+			device.Version--
+			return nil
+		}, persistence.ErrVersionConflict, 3},
+		{"future version", func(device *common.DeviceDTO) error {
+			device.Version++
+			return nil
+		}, persistence.ErrVersionConflict, 3},
+	}
+	for _, test := range tests {
+		version, err := repository.TransactionalUpdateDevice("1", test.updateFn)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expectedErr, err)
+		}
+		if err == nil && version != test.expectedVersion {
+			t.Errorf("%s: expected the committed version %d, got %d", test.name, test.expectedVersion, version)
+		}
+		if device := getDevice(t, repository, "1"); device.Version != test.expectedVersion || device.SignatureCounter != 4 {
+			t.Errorf("%s: expected version %d and counter 4, got %d and %d", test.name, test.expectedVersion, device.Version, device.SignatureCounter)
+		}
+	}
+}
+
+func testDeviceConcurrentUpdates(t *testing.T, repository persistence.DeviceRepository) {
+	start := testDevice("1")
+	start.SignatureCounter = 0
+	if err := repository.SaveDevice(start); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+
+	// every update must see the counter of the previous one
+	var mutex sync.Mutex
+	seen := make(map[uint64]int)
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, concurrentUpdates)
+	for i := 0; i < concurrentUpdates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var counter uint64
+			version, err := repository.TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+				device.SignatureCounter++
+				counter = device.SignatureCounter
+				return nil
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if version != counter+1 {
+				errs <- fmt.Errorf("expected version %d committed with counter %d, got %d", counter+1, counter, version)
+			}
+			mutex.Lock()
+			seen[counter]++
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Cannot update device: %v", err)
+	}
+
+	device := getDevice(t, repository, "1")
+	if device.SignatureCounter != concurrentUpdates || device.Version != concurrentUpdates+1 {
+		t.Errorf("Expected counter %d and version %d, got %d and %d", concurrentUpdates, concurrentUpdates+1, device.SignatureCounter, device.Version)
+	}
+	for counter, times := range seen {
+		if times != 1 {
+			t.Errorf("Expected counter %d to be committed once, got %d times", counter, times)
+		}
+	}
+}
+
+// testSignature returns a signature of the device
+func testSignature(id string, deviceID string, counter uint64) common.SignatureDTO {
+	return common.SignatureDTO{
+		ID:                id,
+		DeviceID:          deviceID,
+		Signature:         "signature " + id,
+		SignedData:        "signed data " + id,
+		Counter:           counter,
+		DataToBeSigned:    "data " + id,
+		PreviousSignature: "previous",
+		Algorithm:         "ECC",
+	}
+}
+
+func testSignatureRoundTrip(t *testing.T, repository persistence.SignatureRepository) {
+	signatures, err := repository.ListSignatures()
+	if err != nil {
+		t.Fatalf("Cannot list signatures: %v", err)
+	}
+	if len(signatures) != 0 {
+		t.Fatalf("Expected an empty repository, got %d signatures", len(signatures))
+	}
+
+	stored := []common.SignatureDTO{
+		testSignature("a1", "A", 1),
+		testSignature("a0", "A", 0),
+		testSignature("b0", "B", 0),
+	}
+	for _, signature := range stored {
+		if err := repository.SaveSignature(signature); err != nil {
+			t.Fatalf("Cannot create signature: %v", err)
+		}
+	}
+	for _, expected := range stored {
+		signature, err := repository.GetSignatureByID(expected.ID)
+		if err != nil {
+			t.Fatalf("Cannot get signature %s: %v", expected.ID, err)
+		}
+		if !reflect.DeepEqual(signature, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, signature)
+		}
+	}
+
+	signatures, err = repository.GetSignaturesByDeviceID("A")
+	if err != nil {
+		t.Fatalf("Cannot get signatures: %v", err)
+	}
+	if ids := signatureIDs(signatures); !reflect.DeepEqual(ids, []string{"a0", "a1"}) {
+		t.Errorf("Expected the signatures [a0 a1] of the device, got %v", ids)
+	}
+	signatures, err = repository.ListSignatures()
+	if err != nil {
+		t.Fatalf("Cannot list signatures: %v", err)
+	}
+	if ids := signatureIDs(signatures); !reflect.DeepEqual(ids, []string{"a0", "a1", "b0"}) {
+		t.Errorf("Expected all the signatures, got %v", ids)
+	}
+}
+
+func testSignatureNotFound(t *testing.T, repository persistence.SignatureRepository) {
+	if _, err := repository.GetSignatureByID("missing"); err != persistence.ErrNotFound {
+		t.Errorf("Expected %v, got %v", persistence.ErrNotFound, err)
+	}
+	// listing the signatures of a device without signatures is not an error
+	signatures, err := repository.GetSignaturesByDeviceID("missing")
+	if err != nil || len(signatures) != 0 {
+		t.Errorf("Expected no signatures, got %d (%v)", len(signatures), err)
+	}
+}
+
+func testSignatureKeyCollision(t *testing.T, repository persistence.SignatureRepository) {
+	first := testSignature("1", "A", 0)
+	if err := repository.SaveSignature(first); err != nil {
+		t.Fatalf("Cannot create signature: %v", err)
+	}
+	if err := repository.SaveSignature(testSignature("1", "B", 7)); err != persistence.ErrIdKeyCollision {
+		t.Errorf("Expected %v, got %v", persistence.ErrIdKeyCollision, err)
+	}
+	signature, err := repository.GetSignatureByID("1")
+	if err != nil {
+		t.Fatalf("Cannot get signature: %v", err)
+	}
+	if !reflect.DeepEqual(signature, first) {
+		t.Errorf("Expected the first signature to be kept, got %+v", signature)
+	}
+}
+
+// signatureIDs returns the sorted IDs of the signatures
+func signatureIDs(signatures []common.SignatureDTO) []string {
+	ids := make([]string, len(signatures))
+	for i, signature := range signatures {
+		ids[i] = signature.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
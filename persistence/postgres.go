@@ -0,0 +1,83 @@
+package persistence
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	// registers the pgx database/sql driver
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresMigrations are the schema migrations of the PostgreSQL database, see sqlDialect
+var postgresMigrations = []string{
+	`CREATE TABLE devices (
+		id                TEXT PRIMARY KEY,
+		label             TEXT,
+		metadata          TEXT,
+		algorithm         TEXT NOT NULL,
+		key_size          INTEGER NOT NULL,
+		curve             TEXT NOT NULL,
+		scheme            TEXT NOT NULL,
+		salt_length       INTEGER NOT NULL,
+		hash              TEXT NOT NULL,
+		key_backend       TEXT NOT NULL,
+		key_label         TEXT NOT NULL,
+		private_key       BYTEA,
+		public_key        BYTEA,
+		kek_id            TEXT NOT NULL,
+		wrapped_key       BYTEA,
+		key_version       INTEGER NOT NULL,
+		key_history       TEXT,
+		status            TEXT NOT NULL,
+		final_counter     BIGINT NOT NULL,
+		decommissioned_at BIGINT NOT NULL,
+		signature_counter BIGINT NOT NULL,
+		last_signature    TEXT NOT NULL
+	);
+	CREATE TABLE signatures (
+		id                 TEXT PRIMARY KEY,
+		device_id          TEXT NOT NULL,
+		signature          TEXT NOT NULL,
+		signed_data        TEXT NOT NULL,
+		counter            BIGINT NOT NULL,
+		data_to_be_signed  TEXT NOT NULL,
+		previous_signature TEXT NOT NULL,
+		algorithm          TEXT NOT NULL,
+		hash               TEXT NOT NULL,
+		key_version        INTEGER NOT NULL,
+		created_at         BIGINT NOT NULL
+	);
+	CREATE INDEX signatures_device_counter ON signatures (device_id, counter, id);
+	CREATE INDEX signatures_created_at ON signatures (created_at, id);`,
+	`ALTER TABLE devices ADD COLUMN version BIGINT NOT NULL DEFAULT 1;`,
+}
+
+// postgresDialect is the dialect of PostgreSQL. The row of a device is locked by
+// TransactionalUpdateDevice, so that the signature counter stays gap-free across
+// the instances of the service sharing the database.
+var postgresDialect = sqlDialect{
+	migrations:           postgresMigrations,
+	numberedPlaceholders: true,
+	lockForUpdate:        " FOR UPDATE",
+	// the key of the lock is arbitrary, it only has to be the same for all the instances
+	lockMigrations: "SELECT pg_advisory_xact_lock(7336353142)",
+	isUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == "23505"
+	},
+}
+
+// postgresMaxOpenConns bounds the connections of an instance, so that several instances
+// fit in the max_connections of the server
+const postgresMaxOpenConns = 20
+
+// OpenPostgresDb connects to the PostgreSQL database of the connection string (URL or
+// key/value format) and migrates its schema to the latest version.
+func OpenPostgresDb(connString string) (*SQLDb, error) {
+	db, err := openSQLDb("pgx", connString, postgresDialect)
+	if err != nil {
+		return nil, err
+	}
+	db.db.SetMaxOpenConns(postgresMaxOpenConns)
+	return db, nil
+}
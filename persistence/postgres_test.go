@@ -0,0 +1,211 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+// EnvTestPostgresURL is the URL of a PostgreSQL server for the integration tests, e.g. of
+// a container. Every test creates its own database on it.
+const EnvTestPostgresURL = "SIGNING_TEST_POSTGRES_URL"
+
+// testPostgres is the server of the integration tests, a temporary cluster is started
+// with initdb if EnvTestPostgresURL is not set
+var testPostgres struct {
+	once    sync.Once
+	url     *url.URL
+	dataDir string
+	err     error
+	count   int
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testPostgres.dataDir != "" {
+		exec.Command("pg_ctl", "-D", testPostgres.dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(testPostgres.dataDir)
+	}
+	os.Exit(code)
+}
+
+// openTestPostgresDb opens a new database on the test server, dropped at the end of the
+// test. The test is skipped if no server is available, or fails on CI (CI=true).
+func openTestPostgresDb(t *testing.T) *SQLDb {
+	t.Helper()
+	connString := createTestPostgresDatabase(t)
+	db, err := OpenPostgresDb(connString)
+	if err != nil {
+		t.Fatalf("Cannot open the database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTestPostgresDatabase creates a database on the test server, dropped at the end of
+// the test, and returns its URL. The test is skipped if no server is available, or fails
+// on CI (CI=true) where the server must be provided.
+func createTestPostgresDatabase(t *testing.T) string {
+	t.Helper()
+	testPostgres.once.Do(func() {
+		if raw := os.Getenv(EnvTestPostgresURL); raw != "" {
+			testPostgres.url, testPostgres.err = url.Parse(raw)
+			return
+		}
+		testPostgres.url, testPostgres.dataDir, testPostgres.err = startTemporaryPostgres()
+	})
+	if testPostgres.err != nil {
+		if ci, _ := strconv.ParseBool(os.Getenv("CI")); ci {
+			t.Fatalf("PostgreSQL is not available on CI (set %s): %v", EnvTestPostgresURL, testPostgres.err)
+		}
+		t.Skipf("PostgreSQL is not available (set %s): %v", EnvTestPostgresURL, testPostgres.err)
+	}
+
+	admin, err := sql.Open("pgx", testPostgres.url.String())
+	if err != nil {
+		t.Fatalf("Cannot connect to PostgreSQL: %v", err)
+	}
+	defer admin.Close()
+	testPostgres.count++
+	name := fmt.Sprintf("signing_test_%d_%d", os.Getpid(), testPostgres.count)
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("Cannot create the test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if admin, err := sql.Open("pgx", testPostgres.url.String()); err == nil {
+			admin.Exec("DROP DATABASE IF EXISTS " + name)
+			admin.Close()
+		}
+	})
+	testURL := *testPostgres.url
+	testURL.Path = "/" + name
+	return testURL.String()
+}
+
+// startTemporaryPostgres initializes and starts a PostgreSQL cluster in a temporary data
+// directory, listening on a free local port
+func startTemporaryPostgres() (*url.URL, string, error) {
+	for _, tool := range []string{"initdb", "pg_ctl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, "", err
+		}
+	}
+	dataDir, err := os.MkdirTemp("", "signing-postgres")
+	if err != nil {
+		return nil, "", err
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, "", err
+	}
+
+	initdb := exec.Command("initdb", "-D", filepath.Join(dataDir, "data"), "-U", "postgres", "--auth=trust")
+	if output, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, "", fmt.Errorf("initdb: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dataDir)
+	start := exec.Command("pg_ctl", "-D", filepath.Join(dataDir, "data"), "-o", options,
+		"-l", filepath.Join(dataDir, "postgres.log"), "-w", "start")
+	if output, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, "", fmt.Errorf("pg_ctl: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	serverURL := &url.URL{
+		Scheme:   "postgres",
+		User:     url.User("postgres"),
+		Host:     fmt.Sprintf("127.0.0.1:%d", port),
+		Path:     "/postgres",
+		RawQuery: "sslmode=disable",
+	}
+	return serverURL, filepath.Join(dataDir, "data"), nil
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// TestPostgresInstances verifies that the signature counter stays gap-free when several
+// instances of the service, each with its own connection pool, update the same device
+func TestPostgresInstances(t *testing.T) {
+	connString := createTestPostgresDatabase(t)
+	first, err := OpenPostgresDb(connString)
+	if err != nil {
+		t.Fatalf("Cannot open the database: %v", err)
+	}
+	defer first.Close()
+	// a second instance on the same database, the migrations are already applied
+	second, err := OpenPostgresDb(connString)
+	if err != nil {
+		t.Fatalf("Cannot open the database: %v", err)
+	}
+	defer second.Close()
+
+	if err := NewSQLDeviceDb(first).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+
+	N := 100
+	wg := &sync.WaitGroup{}
+	for i := 0; i < N; i++ {
+		instance := first
+		if i%2 == 1 {
+			instance = second
+		}
+		wg.Add(1)
+		go func(instance *SQLDb) {
+			defer wg.Done()
+			transactions := NewTransactionManager(NewSQLDeviceDb(instance), NewSQLSignatureDb(instance))
+			_, err := transactions.UpdateDevice("1", func(device *common.DeviceDTO, tx Transaction) error {
+				device.SignatureCounter += 1
+				return tx.SaveSignature(common.SignatureDTO{
+					ID:       fmt.Sprint(device.SignatureCounter),
+					DeviceID: device.ID,
+					Counter:  device.SignatureCounter,
+				})
+			})
+			if err != nil {
+				t.Errorf("Cannot update device: %v", err)
+			}
+		}(instance)
+	}
+	wg.Wait()
+
+	device, err := NewSQLDeviceDb(second).GetDeviceByID("1")
+	if err != nil {
+		t.Fatalf("Cannot get device: %v", err)
+	}
+	if device.SignatureCounter != uint64(N) {
+		t.Errorf("Expected counter %v, got %v", N, device.SignatureCounter)
+	}
+	signatures, err := NewSQLSignatureDb(first).QueryDeviceSignatures("1", common.SignatureQuery{})
+	if err != nil {
+		t.Fatalf("Cannot query signatures: %v", err)
+	}
+	for i, signature := range signatures {
+		if signature.Counter != uint64(i+1) {
+			t.Fatalf("Expected counter %v, got %v", i+1, signature.Counter)
+		}
+	}
+	if len(signatures) != N {
+		t.Errorf("Expected %v signatures, got %v", N, len(signatures))
+	}
+}
@@ -4,12 +4,20 @@ import "github.com/AloveIs/signing-device-service-go/common"
 
 type SignatureRepository interface {
 	// SaveSignature stores a signature in the repository
+	// Returns ErrIdKeyCollision if a signature with the same ID exists
 	SaveSignature(signature common.SignatureDTO) error
 
 	// GetSignaturesByDeviceID retrieves all signatures for a given device ID
 	GetSignaturesByDeviceID(deviceID string) ([]common.SignatureDTO, error)
+	// QueryDeviceSignatures retrieves the signatures of a device in the counter range
+	// of query, ordered by counter and paginated with the offset and limit of query
+	QueryDeviceSignatures(deviceID string, query common.SignatureQuery) ([]common.SignatureDTO, error)
 	// GetSignatureByID retrieves a signature by its ID
+	// Returns ErrNotFound if the signature is not found
 	GetSignatureByID(signatureID string) (common.SignatureDTO, error)
 	// ListSignatures returns all signatures
 	ListSignatures() ([]common.SignatureDTO, error)
+	// QuerySignatures returns the signatures matching the filters of query, ordered by
+	// creation time and ID and paginated after the position and with the limit of query
+	QuerySignatures(query common.SignatureListQuery) ([]common.SignatureDTO, error)
 }
@@ -0,0 +1,188 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlDialect holds what differs between the SQL databases supported by SQLDb
+type sqlDialect struct {
+	// migrations are the schema migrations, applied in order. The version of a migration
+	// is its index plus one: never edit or reorder them, append a new migration instead.
+	migrations []string
+	// numberedPlaceholders replaces the ? placeholders with $1, $2, ...
+	numberedPlaceholders bool
+	// lockForUpdate is appended to the SELECT of a device to lock its row until
+	// the end of the transaction
+	lockForUpdate string
+	// lockMigrations is run at the beginning of every migration transaction, so that
+	// concurrent instances do not apply the same migration
+	lockMigrations string
+	// noLimit is the LIMIT clause of an OFFSET without limit, for databases requiring one
+	noLimit string
+	// serializeWrites is set for databases allowing a single writer
+	serializeWrites bool
+	// isUniqueViolation reports whether err is due to a duplicate key
+	isUniqueViolation func(err error) bool
+}
+
+// SQLDb is a SQL database holding both the devices and the signatures, opened with
+// OpenSQLiteDb or OpenPostgresDb. Its repositories are created with NewSQLDeviceDb
+// and NewSQLSignatureDb.
+type SQLDb struct {
+	db      *sql.DB
+	dialect sqlDialect
+	// writeMutex serializes the writers if the dialect allows a single writer, waiting on
+	// the mutex is cheaper than on the busy timeout of the database
+	writeMutex sync.Mutex
+}
+
+// openSQLDb opens the database and migrates its schema to the latest version
+func openSQLDb(driver, dataSource string, dialect sqlDialect) (*SQLDb, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	sqlDb := &SQLDb{
+		db:      db,
+		dialect: dialect,
+	}
+	if err := sqlDb.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sqlDb, nil
+}
+
+// Close closes the database
+func (s *SQLDb) Close() error {
+	return s.db.Close()
+}
+
+// SchemaVersion returns the version of the last migration applied to the database
+func (s *SQLDb) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+func schemaVersion(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// migrate applies the migrations missing from the database, each one in its own transaction
+func (s *SQLDb) migrate() error {
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		if err := s.lockMigrations(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at BIGINT NOT NULL
+		)`)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create the migrations table: %w", err)
+	}
+
+	migrations := s.dialect.migrations
+	for version := 1; version <= len(migrations); version++ {
+		err := s.inTransaction(func(tx *sql.Tx) error {
+			if err := s.lockMigrations(tx); err != nil {
+				return err
+			}
+			current, err := schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if current > len(migrations) {
+				return fmt.Errorf("database schema version %d is newer than the supported version %d", current, len(migrations))
+			}
+			if current >= version {
+				return nil
+			}
+			if _, err := tx.Exec(migrations[version-1]); err != nil {
+				return err
+			}
+			_, err = tx.Exec(s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+				version, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot apply migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLDb) lockMigrations(tx *sql.Tx) error {
+	if s.dialect.lockMigrations == "" {
+		return nil
+	}
+	_, err := tx.Exec(s.dialect.lockMigrations)
+	return err
+}
+
+// inTransaction runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func (s *SQLDb) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockWrites serializes the writers if the dialect requires it, the returned function
+// releases the lock
+func (s *SQLDb) lockWrites() func() {
+	if !s.dialect.serializeWrites {
+		return func() {}
+	}
+	s.writeMutex.Lock()
+	return s.writeMutex.Unlock
+}
+
+// rebind replaces the ? placeholders of the statement with the ones of the dialect
+func (s *SQLDb) rebind(statement string) string {
+	if !s.dialect.numberedPlaceholders {
+		return statement
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range statement {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// toUnixNano encodes a time for storage, the zero time is stored as 0
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano decodes a time encoded by toUnixNano, in UTC
+func fromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+const sqlDeviceColumns = `id, label, metadata, algorithm, key_size, curve, scheme, salt_length, hash,
+	key_backend, key_label, private_key, public_key, kek_id, wrapped_key, key_version, key_history,
+	status, final_counter, decommissioned_at, signature_counter, last_signature, version`
+
+// SQLDeviceDb implements DeviceRepository on a SQL database
+type SQLDeviceDb struct {
+	db *SQLDb
+}
+
+// NewSQLDeviceDb returns the device repository of the SQL database
+func NewSQLDeviceDb(db *SQLDb) DeviceRepository {
+	return &SQLDeviceDb{db: db}
+}
+
+func (s *SQLDeviceDb) SaveDevice(device common.DeviceDTO) error {
+	device.Version = initialVersion
+	args, err := deviceArgs(device)
+	if err != nil {
+		return err
+	}
+
+	defer s.db.lockWrites()()
+
+	_, err = s.db.db.Exec(s.db.rebind(`INSERT INTO devices (`+sqlDeviceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), args...)
+	if s.db.dialect.isUniqueViolation(err) {
+		return ErrIdKeyCollision
+	}
+	return err
+}
+
+func (s *SQLDeviceDb) GetDeviceByID(id string) (common.DeviceDTO, error) {
+	row := s.db.db.QueryRow(s.db.rebind(`SELECT `+sqlDeviceColumns+` FROM devices WHERE id = ?`), id)
+	return scanDevice(row)
+}
+
+// TransactionalUpdateDevice reads the device, applies updateFn and writes it back in a
+// single database transaction, rolled back if updateFn fails. The row of the device is
+// locked until the transaction ends where the dialect supports it.
+func (s *SQLDeviceDb) TransactionalUpdateDevice(id string, updateFn func(device *common.DeviceDTO) error) (uint64, error) {
+	return s.db.updateDevice(id, func(_ *sql.Tx, device *common.DeviceDTO) error {
+		return updateFn(device)
+	})
+}
+
+// updateDevice reads the device, applies updateFn and writes it back in the transaction
+// passed to updateFn. Returns the committed version of the device.
+func (s *SQLDb) updateDevice(id string, updateFn func(tx *sql.Tx, device *common.DeviceDTO) error) (uint64, error) {
+	defer s.lockWrites()()
+
+	var version uint64
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		row := tx.QueryRow(s.rebind(`SELECT `+sqlDeviceColumns+` FROM devices WHERE id = ?`+s.dialect.lockForUpdate), id)
+		device, err := scanDevice(row)
+		if err != nil {
+			return err
+		}
+		stored := device.Version
+		if err := updateFn(tx, &device); err != nil {
+			return err
+		}
+		if err := nextVersion(stored, &device); err != nil {
+			return err
+		}
+
+		// the ID cannot be changed by updateFn
+		device.ID = id
+		args, err := deviceArgs(device)
+		if err != nil {
+			return err
+		}
+		// the version guards the write where the row is not locked
+		result, err := tx.Exec(s.rebind(`UPDATE devices SET label = ?, metadata = ?, algorithm = ?, key_size = ?,
+			curve = ?, scheme = ?, salt_length = ?, hash = ?, key_backend = ?, key_label = ?,
+			private_key = ?, public_key = ?, kek_id = ?, wrapped_key = ?, key_version = ?,
+			key_history = ?, status = ?, final_counter = ?, decommissioned_at = ?,
+			signature_counter = ?, last_signature = ?, version = ?
+			WHERE id = ? AND version = ?`), append(args[1:], id, int64(stored))...)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrVersionConflict
+		}
+		version = device.Version
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (s *SQLDeviceDb) ListDevices() ([]common.DeviceDTO, error) {
+	return s.QueryDevices(common.DeviceQuery{})
+}
+
+func (s *SQLDeviceDb) QueryDevices(query common.DeviceQuery) ([]common.DeviceDTO, error) {
+	var conditions []string
+	var args []any
+	if query.AfterID != "" {
+		conditions = append(conditions, "id > ?")
+		args = append(args, query.AfterID)
+	}
+	if query.Algorithm != "" {
+		conditions = append(conditions, "algorithm = ?")
+		args = append(args, query.Algorithm)
+	}
+	if query.Label != "" {
+		conditions = append(conditions, "label = ?")
+		args = append(args, query.Label)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	statement := `SELECT ` + sqlDeviceColumns + ` FROM devices`
+	if len(conditions) > 0 {
+		statement += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	statement += ` ORDER BY id`
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.db.Query(s.db.rebind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]common.DeviceDTO, 0)
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// deviceArgs returns the values of the columns of the device, in the order of sqlDeviceColumns
+func deviceArgs(device common.DeviceDTO) ([]any, error) {
+	var metadata, keyHistory []byte
+	var err error
+	if len(device.Metadata) > 0 {
+		if metadata, err = json.Marshal(device.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	if len(device.KeyHistory) > 0 {
+		if keyHistory, err = json.Marshal(device.KeyHistory); err != nil {
+			return nil, err
+		}
+	}
+	return []any{
+		device.ID, device.Label, nullableText(metadata), device.Algorithm, device.KeySize,
+		device.Curve, device.Scheme, device.SaltLength, device.Hash, device.KeyBackend,
+		device.KeyLabel, device.PrivateKey, device.PublicKey, device.KEKID, device.WrappedKey,
+		device.KeyVersion, nullableText(keyHistory), device.Status, int64(device.FinalCounter),
+		toUnixNano(device.DecommissionedAt), int64(device.SignatureCounter), device.LastSignature,
+		int64(device.Version),
+	}, nil
+}
+
+// nullableText stores empty JSON documents as NULL
+func nullableText(text []byte) sql.NullString {
+	return sql.NullString{String: string(text), Valid: len(text) > 0}
+}
+
+// scanDevice reads a device selected with sqlDeviceColumns, it returns ErrNotFound
+// if there is no row
+func scanDevice(row interface{ Scan(dest ...any) error }) (common.DeviceDTO, error) {
+	var device common.DeviceDTO
+	var label, metadata, keyHistory sql.NullString
+	var finalCounter, decommissionedAt, signatureCounter, version int64
+	err := row.Scan(&device.ID, &label, &metadata, &device.Algorithm, &device.KeySize,
+		&device.Curve, &device.Scheme, &device.SaltLength, &device.Hash, &device.KeyBackend,
+		&device.KeyLabel, &device.PrivateKey, &device.PublicKey, &device.KEKID, &device.WrappedKey,
+		&device.KeyVersion, &keyHistory, &device.Status, &finalCounter,
+		&decommissionedAt, &signatureCounter, &device.LastSignature, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.DeviceDTO{}, ErrNotFound
+	}
+	if err != nil {
+		return common.DeviceDTO{}, err
+	}
+
+	if label.Valid {
+		device.Label = &label.String
+	}
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &device.Metadata); err != nil {
+			return common.DeviceDTO{}, err
+		}
+	}
+	if keyHistory.Valid {
+		if err := json.Unmarshal([]byte(keyHistory.String), &device.KeyHistory); err != nil {
+			return common.DeviceDTO{}, err
+		}
+	}
+	device.FinalCounter = uint64(finalCounter)
+	device.DecommissionedAt = fromUnixNano(decommissionedAt)
+	device.SignatureCounter = uint64(signatureCounter)
+	device.Version = uint64(version)
+	return device, nil
+}
@@ -0,0 +1,143 @@
+package persistence
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+const sqlSignatureColumns = `id, device_id, signature, signed_data, counter, data_to_be_signed,
+	previous_signature, algorithm, hash, key_version, created_at`
+
+// SQLSignatureDb implements SignatureRepository on a SQL database
+type SQLSignatureDb struct {
+	db *SQLDb
+}
+
+// NewSQLSignatureDb returns the signature repository of the SQL database
+func NewSQLSignatureDb(db *SQLDb) SignatureRepository {
+	return &SQLSignatureDb{db: db}
+}
+
+func (s *SQLSignatureDb) SaveSignature(signature common.SignatureDTO) error {
+	defer s.db.lockWrites()()
+	return s.db.insertSignature(s.db.db, signature)
+}
+
+// insertSignature inserts the signature with db, a database or a transaction
+func (s *SQLDb) insertSignature(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, signature common.SignatureDTO) error {
+	_, err := db.Exec(s.rebind(`INSERT INTO signatures (`+sqlSignatureColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		signature.ID, signature.DeviceID, signature.Signature, signature.SignedData,
+		int64(signature.Counter), signature.DataToBeSigned, signature.PreviousSignature,
+		signature.Algorithm, signature.Hash, signature.KeyVersion, toUnixNano(signature.CreatedAt))
+	if s.dialect.isUniqueViolation(err) {
+		return ErrIdKeyCollision
+	}
+	return err
+}
+
+func (s *SQLSignatureDb) GetSignaturesByDeviceID(deviceID string) ([]common.SignatureDTO, error) {
+	return s.querySignatures(`WHERE device_id = ?`, deviceID)
+}
+
+func (s *SQLSignatureDb) QueryDeviceSignatures(deviceID string, query common.SignatureQuery) ([]common.SignatureDTO, error) {
+	clauses := `WHERE device_id = ? AND counter >= ?`
+	args := []any{deviceID, int64(query.CounterFrom)}
+	if query.CounterTo != 0 {
+		clauses += ` AND counter < ?`
+		args = append(args, int64(query.CounterTo))
+	}
+	clauses += ` ORDER BY counter, id`
+	if query.Limit > 0 {
+		clauses += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+	if query.Offset > 0 {
+		if query.Limit <= 0 {
+			clauses += s.db.dialect.noLimit
+		}
+		clauses += ` OFFSET ?`
+		args = append(args, query.Offset)
+	}
+	return s.querySignatures(clauses, args...)
+}
+
+func (s *SQLSignatureDb) GetSignatureByID(signatureID string) (common.SignatureDTO, error) {
+	signatures, err := s.querySignatures(`WHERE id = ?`, signatureID)
+	if err != nil {
+		return common.SignatureDTO{}, err
+	}
+	if len(signatures) == 0 {
+		return common.SignatureDTO{}, ErrNotFound
+	}
+	return signatures[0], nil
+}
+
+func (s *SQLSignatureDb) ListSignatures() ([]common.SignatureDTO, error) {
+	return s.querySignatures(``)
+}
+
+func (s *SQLSignatureDb) QuerySignatures(query common.SignatureListQuery) ([]common.SignatureDTO, error) {
+	var conditions []string
+	var args []any
+	if query.DeviceID != "" {
+		conditions = append(conditions, "device_id = ?")
+		args = append(args, query.DeviceID)
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, toUnixNano(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, toUnixNano(query.CreatedTo))
+	}
+	if query.After != nil {
+		createdAt := toUnixNano(query.After.CreatedAt)
+		conditions = append(conditions, "(created_at > ? OR (created_at = ? AND id > ?))")
+		args = append(args, createdAt, createdAt, query.After.ID)
+	}
+
+	var clauses string
+	if len(conditions) > 0 {
+		clauses = `WHERE ` + strings.Join(conditions, " AND ")
+	}
+	clauses += ` ORDER BY created_at, id`
+	if query.Limit > 0 {
+		clauses += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+	return s.querySignatures(clauses, args...)
+}
+
+// querySignatures selects the signatures with the WHERE, ORDER BY and LIMIT clauses
+func (s *SQLSignatureDb) querySignatures(clauses string, args ...any) ([]common.SignatureDTO, error) {
+	rows, err := s.db.db.Query(s.db.rebind(`SELECT `+sqlSignatureColumns+` FROM signatures `+clauses), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signatures := make([]common.SignatureDTO, 0)
+	for rows.Next() {
+		var signature common.SignatureDTO
+		var counter, createdAt int64
+		err := rows.Scan(&signature.ID, &signature.DeviceID, &signature.Signature, &signature.SignedData,
+			&counter, &signature.DataToBeSigned, &signature.PreviousSignature, &signature.Algorithm,
+			&signature.Hash, &signature.KeyVersion, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		signature.Counter = uint64(counter)
+		signature.CreatedAt = fromUnixNano(createdAt)
+		signatures = append(signatures, signature)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return signatures, nil
+}
@@ -0,0 +1,307 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+// openTestSQLiteDb opens a SQLite database in a temporary directory, closed at the end of the test
+func openTestSQLiteDb(t *testing.T) *SQLDb {
+	t.Helper()
+	db, err := OpenSQLiteDb(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Cannot open the database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sqlBackends are the databases the SQL repositories are tested against
+var sqlBackends = []struct {
+	name string
+	open func(t *testing.T) *SQLDb
+}{
+	{"sqlite", openTestSQLiteDb},
+	{"postgres", openTestPostgresDb},
+}
+
+// TestSQLiteMigrations verifies that the schema is migrated once, reopening the database
+// keeps the data and the version
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenSQLiteDb(path)
+	if err != nil {
+		t.Fatalf("Cannot open the database: %v", err)
+	}
+	if version, err := db.SchemaVersion(); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("Expected schema version %d, got %d (%v)", len(sqliteMigrations), version, err)
+	}
+	if err := NewSQLDeviceDb(db).SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	db.Close()
+
+	db, err = OpenSQLiteDb(path)
+	if err != nil {
+		t.Fatalf("Cannot reopen the database: %v", err)
+	}
+	defer db.Close()
+	if version, err := db.SchemaVersion(); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("Expected schema version %d, got %d (%v)", len(sqliteMigrations), version, err)
+	}
+	if _, err := NewSQLDeviceDb(db).GetDeviceByID("1"); err != nil {
+		t.Errorf("Cannot get device after reopening: %v", err)
+	}
+}
+
+// TestSQLRepositories runs the tests of the SQL repositories on every backend
+func TestSQLRepositories(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, sqlDb *SQLDb)
+	}{
+		{"DeviceRoundTrip", testSQLDeviceRoundTrip},
+		{"TransactionalUpdate", testSQLTransactionalUpdate},
+		{"Queries", testSQLQueries},
+	}
+	for _, backend := range sqlBackends {
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				test.run(t, backend.open(t))
+			})
+		}
+	}
+}
+
+// testSQLDeviceRoundTrip verifies that every field of a device is stored
+func testSQLDeviceRoundTrip(t *testing.T, sqlDb *SQLDb) {
+	db := NewSQLDeviceDb(sqlDb)
+
+	label := "register 1"
+	device := common.DeviceDTO{
+		ID:               "1",
+		Label:            &label,
+		Metadata:         map[string]string{"store": "42"},
+		Algorithm:        "RSA",
+		KeySize:          2048,
+		Scheme:           "PSS",
+		SaltLength:       32,
+		Hash:             "SHA-256",
+		KeyBackend:       "software",
+		KeyLabel:         "key-1",
+		PrivateKey:       []byte("private"),
+		PublicKey:        []byte("public"),
+		KEKID:            "kek",
+		WrappedKey:       []byte("wrapped"),
+		KeyVersion:       2,
+		KeyHistory:       []common.RetiredKey{{Version: 1, PublicKey: "old", CounterFrom: 0, CounterTo: 3}},
+		Status:           "DECOMMISSIONED",
+		FinalCounter:     7,
+		DecommissionedAt: time.Date(2024, 5, 1, 10, 0, 0, 123, time.UTC),
+		SignatureCounter: 7,
+		LastSignature:    "c2lnbmF0dXJl",
+		Version:          1,
+	}
+	if err := db.SaveDevice(device); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	got, err := db.GetDeviceByID("1")
+	if err != nil {
+		t.Fatalf("Cannot get device: %v", err)
+	}
+	if !reflect.DeepEqual(got, device) {
+		t.Errorf("Expected %+v, got %+v", device, got)
+	}
+
+	// optional fields are stored as missing
+	if err := db.SaveDevice(common.DeviceDTO{ID: "2", Algorithm: "ECC"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+	got, err = db.GetDeviceByID("2")
+	if err != nil {
+		t.Fatalf("Cannot get device: %v", err)
+	}
+	if got.Label != nil || got.Metadata != nil || got.KeyHistory != nil || !got.DecommissionedAt.IsZero() {
+		t.Errorf("Expected no optional fields, got %+v", got)
+	}
+
+	if err := db.SaveDevice(common.DeviceDTO{ID: "1"}); err != ErrIdKeyCollision {
+		t.Errorf("Expected %v, got %v", ErrIdKeyCollision, err)
+	}
+	if _, err := db.GetDeviceByID("3"); err != ErrNotFound {
+		t.Errorf("Expected %v, got %v", ErrNotFound, err)
+	}
+	if _, err := db.TransactionalUpdateDevice("3", func(*common.DeviceDTO) error { return nil }); err != ErrNotFound {
+		t.Errorf("Expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+// testSQLTransactionalUpdate verifies that concurrent units of work are serialized and
+// commit the device together with its signatures
+func testSQLTransactionalUpdate(t *testing.T, sqlDb *SQLDb) {
+	deviceDb := NewSQLDeviceDb(sqlDb)
+	signatureDb := NewSQLSignatureDb(sqlDb)
+	transactions := NewTransactionManager(deviceDb, signatureDb)
+	if err := deviceDb.SaveDevice(common.DeviceDTO{ID: "1"}); err != nil {
+		t.Fatalf("Cannot create device: %v", err)
+	}
+
+	N := 200
+	wg := &sync.WaitGroup{}
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := transactions.UpdateDevice("1", func(device *common.DeviceDTO, tx Transaction) error {
+				device.SignatureCounter += 1
+				return tx.SaveSignature(common.SignatureDTO{
+					ID:       fmt.Sprint(device.SignatureCounter),
+					DeviceID: device.ID,
+					Counter:  device.SignatureCounter,
+				})
+			})
+			if err != nil {
+				t.Errorf("Cannot update device: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	device, err := deviceDb.GetDeviceByID("1")
+	if err != nil {
+		t.Fatalf("Cannot get device: %v", err)
+	}
+	if device.SignatureCounter != uint64(N) {
+		t.Errorf("Expected counter %v, got %v", N, device.SignatureCounter)
+	}
+	signatures, err := signatureDb.GetSignaturesByDeviceID("1")
+	if err != nil || len(signatures) != N {
+		t.Fatalf("Expected %v signatures, got %v (%v)", N, len(signatures), err)
+	}
+
+	failure := errors.New("failure")
+	_, err = deviceDb.TransactionalUpdateDevice("1", func(device *common.DeviceDTO) error {
+		device.SignatureCounter += 1
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Expected %v, got %v", failure, err)
+	}
+	if device, _ := deviceDb.GetDeviceByID("1"); device.SignatureCounter != uint64(N) {
+		t.Errorf("Expected counter %v after rollback, got %v", N, device.SignatureCounter)
+	}
+}
+
+// testSQLQueries verifies filters, ordering and pagination of the queries
+func testSQLQueries(t *testing.T, sqlDb *SQLDb) {
+	deviceDb := NewSQLDeviceDb(sqlDb)
+	signatureDb := NewSQLSignatureDb(sqlDb)
+
+	label := "front"
+	for _, device := range []common.DeviceDTO{
+		{ID: "c", Algorithm: "RSA", Status: "ACTIVE"},
+		{ID: "a", Algorithm: "ECC", Status: "ACTIVE", Label: &label},
+		{ID: "b", Algorithm: "ECC", Status: "SUSPENDED"},
+	} {
+		if err := deviceDb.SaveDevice(device); err != nil {
+			t.Fatalf("Cannot create device: %v", err)
+		}
+	}
+	deviceTests := []struct {
+		query    common.DeviceQuery
+		expected []string
+	}{
+		{common.DeviceQuery{}, []string{"a", "b", "c"}},
+		{common.DeviceQuery{Algorithm: "ECC"}, []string{"a", "b"}},
+		{common.DeviceQuery{Label: "front"}, []string{"a"}},
+		{common.DeviceQuery{Status: "ACTIVE", AfterID: "a"}, []string{"c"}},
+		{common.DeviceQuery{Limit: 2}, []string{"a", "b"}},
+	}
+	for _, test := range deviceTests {
+		devices, err := deviceDb.QueryDevices(test.query)
+		if err != nil {
+			t.Fatalf("Cannot query devices: %v", err)
+		}
+		ids := make([]string, 0, len(devices))
+		for _, device := range devices {
+			ids = append(ids, device.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.query, test.expected, ids)
+		}
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, signature := range []common.SignatureDTO{
+		{ID: "s3", DeviceID: "a", Counter: 2, CreatedAt: start.Add(2 * time.Second)},
+		{ID: "s1", DeviceID: "a", Counter: 0, CreatedAt: start},
+		{ID: "s2", DeviceID: "a", Counter: 1, CreatedAt: start.Add(time.Second)},
+		{ID: "s4", DeviceID: "b", Counter: 0, CreatedAt: start.Add(time.Second)},
+	} {
+		if err := signatureDb.SaveSignature(signature); err != nil {
+			t.Fatalf("Cannot create signature %d: %v", i, err)
+		}
+	}
+	if err := signatureDb.SaveSignature(common.SignatureDTO{ID: "s1"}); err != ErrIdKeyCollision {
+		t.Errorf("Expected %v, got %v", ErrIdKeyCollision, err)
+	}
+	signature, err := signatureDb.GetSignatureByID("s2")
+	if err != nil || !signature.CreatedAt.Equal(start.Add(time.Second)) || signature.Counter != 1 {
+		t.Errorf("Unexpected signature %+v (%v)", signature, err)
+	}
+
+	deviceSignatureTests := []struct {
+		query    common.SignatureQuery
+		expected []string
+	}{
+		{common.SignatureQuery{}, []string{"s1", "s2", "s3"}},
+		{common.SignatureQuery{CounterFrom: 1}, []string{"s2", "s3"}},
+		{common.SignatureQuery{CounterTo: 2}, []string{"s1", "s2"}},
+		{common.SignatureQuery{Offset: 1}, []string{"s2", "s3"}},
+		{common.SignatureQuery{Offset: 1, Limit: 1}, []string{"s2"}},
+	}
+	for _, test := range deviceSignatureTests {
+		signatures, err := signatureDb.QueryDeviceSignatures("a", test.query)
+		if err != nil {
+			t.Fatalf("Cannot query signatures: %v", err)
+		}
+		if ids := signatureIDs(signatures); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.query, test.expected, ids)
+		}
+	}
+
+	signatureTests := []struct {
+		query    common.SignatureListQuery
+		expected []string
+	}{
+		{common.SignatureListQuery{}, []string{"s1", "s2", "s4", "s3"}},
+		{common.SignatureListQuery{DeviceID: "b"}, []string{"s4"}},
+		{common.SignatureListQuery{CreatedFrom: start.Add(time.Second), CreatedTo: start.Add(2 * time.Second)}, []string{"s2", "s4"}},
+		{common.SignatureListQuery{After: &common.SignaturePosition{CreatedAt: start.Add(time.Second), ID: "s2"}}, []string{"s4", "s3"}},
+		{common.SignatureListQuery{Limit: 1}, []string{"s1"}},
+	}
+	for _, test := range signatureTests {
+		signatures, err := signatureDb.QuerySignatures(test.query)
+		if err != nil {
+			t.Fatalf("Cannot query signatures: %v", err)
+		}
+		if ids := signatureIDs(signatures); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.query, test.expected, ids)
+		}
+	}
+}
+
+func signatureIDs(signatures []common.SignatureDTO) []string {
+	ids := make([]string, 0, len(signatures))
+	for _, signature := range signatures {
+		ids = append(ids, signature.ID)
+	}
+	return ids
+}
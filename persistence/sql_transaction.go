@@ -0,0 +1,27 @@
+package persistence
+
+import (
+	"database/sql"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+)
+
+// sqlTransactionManager commits units of work in a single transaction of a SQLDb
+type sqlTransactionManager struct {
+	db *SQLDb
+}
+
+func (m *sqlTransactionManager) UpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO, tx Transaction) error) (uint64, error) {
+	return m.db.updateDevice(deviceID, func(sqlTx *sql.Tx, device *common.DeviceDTO) error {
+		tx := &pendingTransaction{}
+		if err := updateFn(device, tx); err != nil {
+			return err
+		}
+		for _, signature := range tx.signatures {
+			if err := m.db.insertSignature(sqlTx, signature); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
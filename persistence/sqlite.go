@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"errors"
+	"net/url"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteMigrations are the schema migrations of the SQLite database, see sqlDialect
+var sqliteMigrations = []string{
+	`CREATE TABLE devices (
+		id                TEXT PRIMARY KEY,
+		label             TEXT,
+		metadata          TEXT,
+		algorithm         TEXT NOT NULL,
+		key_size          INTEGER NOT NULL,
+		curve             TEXT NOT NULL,
+		scheme            TEXT NOT NULL,
+		salt_length       INTEGER NOT NULL,
+		hash              TEXT NOT NULL,
+		key_backend       TEXT NOT NULL,
+		key_label         TEXT NOT NULL,
+		private_key       BLOB,
+		public_key        BLOB,
+		kek_id            TEXT NOT NULL,
+		wrapped_key       BLOB,
+		key_version       INTEGER NOT NULL,
+		key_history       TEXT,
+		status            TEXT NOT NULL,
+		final_counter     INTEGER NOT NULL,
+		decommissioned_at INTEGER NOT NULL,
+		signature_counter INTEGER NOT NULL,
+		last_signature    TEXT NOT NULL
+	);
+	CREATE TABLE signatures (
+		id                 TEXT PRIMARY KEY,
+		device_id          TEXT NOT NULL,
+		signature          TEXT NOT NULL,
+		signed_data        TEXT NOT NULL,
+		counter            INTEGER NOT NULL,
+		data_to_be_signed  TEXT NOT NULL,
+		previous_signature TEXT NOT NULL,
+		algorithm          TEXT NOT NULL,
+		hash               TEXT NOT NULL,
+		key_version        INTEGER NOT NULL,
+		created_at         INTEGER NOT NULL
+	);`,
+	`CREATE INDEX signatures_device_counter ON signatures (device_id, counter, id);
+	CREATE INDEX signatures_created_at ON signatures (created_at, id);`,
+	`ALTER TABLE devices ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+}
+
+// sqliteDialect is the dialect of SQLite, which allows a single writer: the transactions
+// take the write lock when they begin
+var sqliteDialect = sqlDialect{
+	migrations: sqliteMigrations,
+	// a negative limit is unbounded
+	noLimit:         " LIMIT -1",
+	serializeWrites: true,
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	},
+}
+
+// OpenSQLiteDb opens (or creates) the SQLite database at path and migrates its schema
+// to the latest version.
+func OpenSQLiteDb(path string) (*SQLDb, error) {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(FULL)")
+	// take the write lock when the transaction begins, so that transactions reading
+	// before writing cannot fail to upgrade their lock
+	params.Set("_txlock", "immediate")
+
+	return openSQLDb("sqlite", "file:"+path+"?"+params.Encode(), sqliteDialect)
+}
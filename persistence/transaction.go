@@ -0,0 +1,92 @@
+package persistence
+
+import "github.com/AloveIs/signing-device-service-go/common"
+
+// TransactionManager runs units of work spanning the device and the signature repositories.
+type TransactionManager interface {
+	// UpdateDevice applies updateFn to the device like DeviceRepository.TransactionalUpdateDevice,
+	// the signatures saved with tx are committed together with the device: either all the
+	// writes are committed or none is. Nothing is written if updateFn fails.
+	// updateFn must not use the repositories, updates of the same device are serialized.
+	// Returns ErrNotFound if the device does not exist, ErrIdKeyCollision if a signature ID
+	// is taken and ErrVersionConflict if updateFn changed the version of the device.
+	// Returns the version of the device that has been committed.
+	UpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO, tx Transaction) error) (uint64, error)
+}
+
+// Transaction collects the writes of a unit of work, performed when it commits
+type Transaction interface {
+	// SaveSignature stores a signature when the transaction commits
+	SaveSignature(signature common.SignatureDTO) error
+}
+
+// NewTransactionManager returns the transaction manager of the repositories. The writes are
+// atomic if both repositories are of the same backend (in-memory, the same journal or the
+// same SQL database), the device repository may be encrypted with NewEncryptedDeviceDb.
+// For other repositories the signatures are saved within TransactionalUpdateDevice before
+// the device is written and are not rolled back if the device write fails.
+func NewTransactionManager(devices DeviceRepository, signatures SignatureRepository) TransactionManager {
+	switch devices := devices.(type) {
+	case *EncryptedDeviceDb:
+		return &encryptedTransactionManager{
+			manager: NewTransactionManager(devices.repo, signatures),
+			db:      devices,
+		}
+	case *InMemoryDeviceDb:
+		if signatures, ok := signatures.(*InMemorySignatureDb); ok && signatures.journal == devices.journal {
+			return &inMemoryTransactionManager{devices: devices, signatures: signatures}
+		}
+	case *SQLDeviceDb:
+		if signatures, ok := signatures.(*SQLSignatureDb); ok && signatures.db == devices.db {
+			return &sqlTransactionManager{db: devices.db}
+		}
+	}
+	return &repositoryTransactionManager{devices: devices, signatures: signatures}
+}
+
+// pendingTransaction buffers the writes of a unit of work until it commits
+type pendingTransaction struct {
+	signatures []common.SignatureDTO
+}
+
+func (tx *pendingTransaction) SaveSignature(signature common.SignatureDTO) error {
+	tx.signatures = append(tx.signatures, signature)
+	return nil
+}
+
+// repositoryTransactionManager is the best-effort TransactionManager of repositories
+// without a common transaction
+type repositoryTransactionManager struct {
+	devices    DeviceRepository
+	signatures SignatureRepository
+}
+
+func (m *repositoryTransactionManager) UpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO, tx Transaction) error) (uint64, error) {
+	return m.devices.TransactionalUpdateDevice(deviceID, func(device *common.DeviceDTO) error {
+		tx := &pendingTransaction{}
+		if err := updateFn(device, tx); err != nil {
+			return err
+		}
+		for _, signature := range tx.signatures {
+			if err := m.signatures.SaveSignature(signature); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encryptedTransactionManager encrypts the private keys of the devices of the units
+// of work like EncryptedDeviceDb
+type encryptedTransactionManager struct {
+	manager TransactionManager
+	db      *EncryptedDeviceDb
+}
+
+func (m *encryptedTransactionManager) UpdateDevice(deviceID string, updateFn func(device *common.DeviceDTO, tx Transaction) error) (uint64, error) {
+	return m.manager.UpdateDevice(deviceID, func(stored *common.DeviceDTO, tx Transaction) error {
+		return m.db.update(stored, func(device *common.DeviceDTO) error {
+			return updateFn(device, tx)
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/AloveIs/signing-device-service-go/common"
+	"github.com/AloveIs/signing-device-service-go/crypto"
+)
+
+// transactionBackends are the repositories whose units of work must be atomic
+var transactionBackends = []struct {
+	name string
+	open func(t *testing.T) (DeviceRepository, SignatureRepository)
+	// failDeviceWrites makes the device writes of the repositories fail after the signatures
+	// are written, nil if the backend cannot fail between the two
+	failDeviceWrites func(t *testing.T, devices DeviceRepository)
+}{
+	{"inmemory", func(t *testing.T) (DeviceRepository, SignatureRepository) {
+		return NewInMemoryDeviceDb(), NewInMemorySignatureDb()
+	}, nil},
+	{"encrypted", func(t *testing.T) (DeviceRepository, SignatureRepository) {
+		kek, err := crypto.GenerateKEK("kek")
+		if err != nil {
+			t.Fatalf("Cannot generate KEK: %v", err)
+		}
+		journal := openTestJournalDb(t, t.TempDir())
+		return NewEncryptedDeviceDb(NewJournalDeviceDb(journal), kek), NewJournalSignatureDb(journal)
+	}, func(t *testing.T, devices DeviceRepository) {
+		closeTestJournal(devices.(*EncryptedDeviceDb).repo)
+	}},
+	{"journal", func(t *testing.T) (DeviceRepository, SignatureRepository) {
+		journal := openTestJournalDb(t, t.TempDir())
+		return NewJournalDeviceDb(journal), NewJournalSignatureDb(journal)
+	}, func(t *testing.T, devices DeviceRepository) {
+		closeTestJournal(devices)
+	}},
+	{"sqlite", func(t *testing.T) (DeviceRepository, SignatureRepository) {
+		db := openTestSQLiteDb(t)
+		return NewSQLDeviceDb(db), NewSQLSignatureDb(db)
+	}, func(t *testing.T, devices DeviceRepository) {
+		execTestSQL(t, devices, `CREATE TRIGGER fail_device_write BEFORE UPDATE ON devices
+			BEGIN SELECT RAISE(ABORT, 'failure'); END`)
+	}},
+	{"postgres", func(t *testing.T) (DeviceRepository, SignatureRepository) {
+		db := openTestPostgresDb(t)
+		return NewSQLDeviceDb(db), NewSQLSignatureDb(db)
+	}, func(t *testing.T, devices DeviceRepository) {
+		execTestSQL(t, devices,
+			`CREATE FUNCTION fail_device_write() RETURNS trigger AS $$
+				BEGIN RAISE EXCEPTION 'failure'; END $$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER fail_device_write BEFORE UPDATE ON devices
+				FOR EACH ROW EXECUTE FUNCTION fail_device_write()`)
+	}},
+}
+
+// closeTestJournal closes the journal of the device repository, the units of work
+// cannot be appended anymore
+func closeTestJournal(devices DeviceRepository) {
+	devices.(*InMemoryDeviceDb).journal.file.Close()
+}
+
+// execTestSQL runs the statements on the database of the SQL device repository
+func execTestSQL(t *testing.T, devices DeviceRepository, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if _, err := devices.(*SQLDeviceDb).db.db.Exec(statement); err != nil {
+			t.Fatalf("Cannot run %q: %v", statement, err)
+		}
+	}
+}
+
+// TestTransactionManager verifies that the device and the signatures of a unit of work
+// are committed or rolled back together on every backend
+func TestTransactionManager(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name string
+		// signatures saved by the unit of work
+		signatures []string
+		// failAfterSave makes updateFn fail after saving the signatures
+		failAfterSave bool
+		// failDeviceWrite makes the device write fail after the signatures are written
+		failDeviceWrite bool
+		expectedErr     error
+	}{
+		{name: "commit", signatures: []string{"s1", "s2"}},
+		{name: "updateFn failure", signatures: []string{"s1"}, failAfterSave: true, expectedErr: failure},
+		{name: "failure between writes", signatures: []string{"s1", "s2"}, failDeviceWrite: true},
+		{name: "signature collision", signatures: []string{"s1", "existing"}, expectedErr: ErrIdKeyCollision},
+		{name: "duplicate signatures", signatures: []string{"s1", "s1"}, expectedErr: ErrIdKeyCollision},
+	}
+
+	for _, backend := range transactionBackends {
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				if test.failDeviceWrite && backend.failDeviceWrites == nil {
+					t.Skip("The device write cannot fail after the signatures are written")
+				}
+				devices, signatures := backend.open(t)
+				transactions := NewTransactionManager(devices, signatures)
+				privateKey := []byte("private key")
+				if err := devices.SaveDevice(common.DeviceDTO{ID: "1", PrivateKey: privateKey}); err != nil {
+					t.Fatalf("Cannot create device: %v", err)
+				}
+				if err := signatures.SaveSignature(common.SignatureDTO{ID: "existing", DeviceID: "2"}); err != nil {
+					t.Fatalf("Cannot create signature: %v", err)
+				}
+				if test.failDeviceWrite {
+					backend.failDeviceWrites(t, devices)
+				}
+
+				_, err := transactions.UpdateDevice("1", func(device *common.DeviceDTO, tx Transaction) error {
+					if !bytes.Equal(device.PrivateKey, privateKey) {
+						t.Errorf("Expected the plain private key, got %q", device.PrivateKey)
+					}
+					for _, id := range test.signatures {
+						device.SignatureCounter++
+						if err := tx.SaveSignature(common.SignatureDTO{ID: id, DeviceID: "1", Counter: device.SignatureCounter}); err != nil {
+							return err
+						}
+					}
+					if test.failAfterSave {
+						return failure
+					}
+					return nil
+				})
+				if test.failDeviceWrite {
+					if err == nil {
+						t.Fatal("Expected the device write to fail")
+					}
+				} else if err != test.expectedErr {
+					t.Fatalf("Expected %v, got %v", test.expectedErr, err)
+				}
+
+				device, err := devices.GetDeviceByID("1")
+				if err != nil {
+					t.Fatalf("Cannot get device: %v", err)
+				}
+				stored, err := signatures.GetSignaturesByDeviceID("1")
+				if err != nil {
+					t.Fatalf("Cannot get signatures: %v", err)
+				}
+				if test.expectedErr == nil && !test.failDeviceWrite {
+					if device.SignatureCounter != uint64(len(test.signatures)) || len(stored) != len(test.signatures) {
+						t.Errorf("Expected counter and signatures %d, got %d and %d", len(test.signatures), device.SignatureCounter, len(stored))
+					}
+				} else if device.SignatureCounter != 0 || len(stored) != 0 {
+					t.Errorf("Expected nothing committed, got counter %d and %d signatures", device.SignatureCounter, len(stored))
+				}
+				if !bytes.Equal(device.PrivateKey, privateKey) {
+					t.Errorf("Expected the private key to be unchanged, got %q", device.PrivateKey)
+				}
+			})
+		}
+	}
+}
+
+// TestTransactionManagerNotFound verifies that units of work on missing devices write nothing
+func TestTransactionManagerNotFound(t *testing.T) {
+	for _, backend := range transactionBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			devices, signatures := backend.open(t)
+			_, err := NewTransactionManager(devices, signatures).UpdateDevice("1", func(device *common.DeviceDTO, tx Transaction) error {
+				return tx.SaveSignature(common.SignatureDTO{ID: "s1", DeviceID: "1"})
+			})
+			if err != ErrNotFound {
+				t.Errorf("Expected %v, got %v", ErrNotFound, err)
+			}
+			if _, err := signatures.GetSignatureByID("s1"); err != ErrNotFound {
+				t.Errorf("Expected no signature, got %v", err)
+			}
+		})
+	}
+}